@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// listUsers returns a filtered page of users for the admin UI
+func listUsers(c *gin.Context) {
+	pagination, paginate, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !paginate {
+		// Always page the user list so it scales
+		pagination = Pagination{Page: 1, PerPage: defaultPageSize}
+	}
+
+	query := db.WithContext(c.Request.Context()).Model(&User{})
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := likePattern(q)
+		query = query.Where(`username ILIKE ? ESCAPE '\' OR email ILIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
+	if activeStr, ok := c.GetQuery("active"); ok {
+		active, err := strconv.ParseBool(activeStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid active filter"})
+			return
+		}
+		query = query.Where("active = ?", active)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch users")
+		return
+	}
+	setPaginationHeaders(c, pagination, total)
+
+	users := []User{}
+	if err := query.Order("id ASC").Offset(pagination.Offset()).Limit(pagination.PerPage).Find(&users).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch users")
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// UserDigest summarises a user's outstanding work for the nightly digest
+type UserDigest struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Pending  int64  `json:"pending"`
+}
+
+// getDigest returns per-user pending task counts for active users
+func getDigest(c *gin.Context) {
+	minPending := 0
+	if minStr, ok := c.GetQuery("min_pending"); ok {
+		var err error
+		if minPending, err = strconv.Atoi(minStr); err != nil || minPending < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_pending"})
+			return
+		}
+	}
+
+	digests := []UserDigest{}
+	if err := db.WithContext(c.Request.Context()).Table("users").
+		Select("users.id AS user_id, users.username, users.email, COUNT(tasks.id) AS pending").
+		Joins("LEFT JOIN tasks ON tasks.user_id = users.id AND tasks.completed = ? AND tasks.archived_at IS NULL", false).
+		Where("users.active = ? AND users.notify_digest = ?", true, true).
+		Group("users.id, users.username, users.email").
+		Having("COUNT(tasks.id) >= ?", minPending).
+		Order("users.id ASC").
+		Scan(&digests).Error; err != nil {
+		respondDBError(c, err, "Failed to build digest")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"generated_at": time.Now(),
+		"users":        digests,
+	})
+}
+
+// impersonateUser issues a short-lived token acting as another user
+func impersonateUser(c *gin.Context) {
+	adminID := c.GetUint("user_id")
+	userIDStr := c.Param("user_id")
+
+	var userID uint
+	if _, err := fmt.Sscanf(userIDStr, "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot impersonate an admin"})
+		return
+	}
+	if !user.Active {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+
+	token, expiresAt, err := generateImpersonationToken(user.ID, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	slog.Info("Admin impersonation started", "admin_id", adminID, "user_id", user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":           token,
+		"expires_at":      expiresAt,
+		"impersonated_by": adminID,
+		"user": gin.H{
+			"id":       user.ID,
+			"username": user.Username,
+			"email":    user.Email,
+		},
+	})
+}
+
+// deactivateUser suspends a user and revokes their outstanding tokens
+func deactivateUser(c *gin.Context) {
+	setUserActive(c, false)
+}
+
+// reactivateUser lifts a user's suspension
+func reactivateUser(c *gin.Context) {
+	setUserActive(c, true)
+}
+
+func setUserActive(c *gin.Context, active bool) {
+	userIDStr := c.Param("id")
+
+	var userID uint
+	if _, err := fmt.Sscanf(userIDStr, "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if !active && userID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot deactivate your own account"})
+		return
+	}
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	updates := map[string]interface{}{"active": active}
+	if !active {
+		// Log the user out everywhere
+		updates["tokens_revoked_at"] = time.Now()
+	}
+
+	if err := db.WithContext(c.Request.Context()).Model(&user).Updates(updates).Error; err != nil {
+		respondDBError(c, err, "Failed to update user")
+		return
+	}
+	user.Active = active
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       user.ID,
+		"username": user.Username,
+		"active":   user.Active,
+	})
+}
+
+// TransferTasksRequest names the user who receives the tasks
+type TransferTasksRequest struct {
+	TargetUserID uint `json:"target_user_id" binding:"required"`
+}
+
+// transferTasks moves every task from one user to another, for example when
+// someone leaves. Client IDs belong to the old owner's devices, so they are
+// cleared, and the old owner's sync clients get tombstones for the tasks.
+func transferTasks(c *gin.Context) {
+	adminID := c.GetUint("user_id")
+
+	var sourceID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &sourceID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req TransferTasksRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.TargetUserID == sourceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and target users must be different"})
+		return
+	}
+
+	var source, target User
+	if err := db.WithContext(c.Request.Context()).First(&source, sourceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := db.WithContext(c.Request.Context()).First(&target, req.TargetUserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target user not found"})
+		return
+	}
+
+	ids := []uint{}
+	var tasks []Task
+	err := db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id", "uuid").Where("user_id = ?", source.ID).Order("id ASC").Find(&tasks).Error; err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			return nil
+		}
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+
+		if err := tx.Model(&Task{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"user_id":    target.ID,
+			"client_id":  nil,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&TaskDependency{}).Where("task_id IN ?", ids).Update("user_id", target.ID).Error
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to transfer tasks")
+		return
+	}
+
+	recordTombstones(c.Request.Context(), source.ID, tasks)
+
+	slog.Info("Admin transferred tasks",
+		"admin_id", adminID,
+		"source_user_id", source.ID,
+		"target_user_id", target.ID,
+		"count", len(ids),
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"source_user_id": source.ID,
+		"target_user_id": target.ID,
+		"ids":            taskPublicIDs(tasks),
+		"transferred":    len(ids),
+	})
+}
+
+// getDBStats reports connection pool statistics for diagnosing exhaustion
+func getDBStats(c *gin.Context) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to access database pool"})
+		return
+	}
+
+	stats := sqlDB.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration_ms":     stats.WaitDuration.Milliseconds(),
+		"max_idle_closed":      stats.MaxIdleClosed,
+		"max_idle_time_closed": stats.MaxIdleTimeClosed,
+		"max_lifetime_closed":  stats.MaxLifetimeClosed,
+	})
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,43 +16,99 @@ import (
 // Claims represents JWT claims
 type Claims struct {
 	UserID uint `json:"user_id"`
+	// ImpersonatedBy is the admin acting as UserID, set only on impersonation tokens
+	ImpersonatedBy uint `json:"impersonated_by,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Token lifetimes
+const (
+	tokenTTL         = 24 * time.Hour
+	impersonationTTL = 15 * time.Minute
+)
+
 // authMiddleware validates JWT tokens
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+		// Behind an SSO gateway the identity header replaces the JWT
+		if gatewayAuth() {
+			userID, ok := gatewayUser(c)
+			if !ok {
+				c.Abort()
+				return
+			}
+			c.Set("user_id", userID)
+			c.Next()
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+		tokenString, err := requestToken(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
 		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(getJWTSecret()), nil
-		})
+		claims, err := parseToken(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		// Reject deactivated accounts and revoked tokens
+		if status, err := authorizeClaims(c.Request.Context(), claims); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(*Claims); ok {
-			c.Set("user_id", claims.UserID)
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		c.Set("user_id", claims.UserID)
+		c.Set("claims", claims)
+		if claims.ImpersonatedBy != 0 {
+			c.Set("impersonated_by", claims.ImpersonatedBy)
+			c.Header("X-Impersonated-By", strconv.FormatUint(uint64(claims.ImpersonatedBy), 10))
+		}
+
+		c.Next()
+	}
+}
+
+// requestToken extracts the JWT from the Authorization header, falling back to
+// the auth cookie in cookie mode
+func requestToken(c *gin.Context) (string, error) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		if cookieAuth() {
+			if cookie, err := c.Cookie(authCookieName); err == nil && cookie != "" {
+				return cookie, nil
+			}
+		}
+		return "", errors.New("Authorization header required")
+	}
+
+	// Extract token from "Bearer <token>"
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return "", errors.New("Invalid token format")
+	}
+	return tokenString, nil
+}
+
+// adminMiddleware restricts access to admin users, must run after authMiddleware
+func adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Impersonation tokens never carry admin rights
+		if c.GetUint("impersonated_by") != 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		var user User
+		if err := db.WithContext(c.Request.Context()).Select("id", "is_admin").First(&user, c.GetUint("user_id")).Error; err != nil || !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
 			c.Abort()
 			return
 		}
@@ -58,19 +117,82 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// authorizeClaims checks that the token's user exists, is active and has not had its tokens revoked
+func authorizeClaims(ctx context.Context, claims *Claims) (int, error) {
+	var user User
+	if err := db.WithContext(ctx).Select("id", "active", "tokens_revoked_at").First(&user, claims.UserID).Error; err != nil {
+		return http.StatusUnauthorized, errors.New("User not found")
+	}
+
+	if !user.Active {
+		return http.StatusForbidden, errors.New("Account is deactivated")
+	}
+
+	// iat only has whole seconds, so compare at that precision; otherwise a
+	// token issued just after a revocation, in the same second, looks older
+	if user.TokensRevokedAt != nil && claims.IssuedAt != nil &&
+		claims.IssuedAt.Time.Before(user.TokensRevokedAt.Truncate(time.Second)) {
+		return http.StatusUnauthorized, errors.New("Token has been revoked")
+	}
+
+	return 0, nil
+}
+
+// parseToken validates a JWT token string and returns its claims. Only the
+// configured algorithm is accepted, so an RS256 public key can never be
+// replayed as an HS256 secret.
+func parseToken(tokenString string) (*Claims, error) {
+	keys, err := getJWTKeys()
+	if err != nil {
+		return nil, errors.New("Invalid token")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		return jwt.VerificationKeySet{Keys: keys.verifyKeys}, nil
+	}, jwt.WithValidMethods([]string{keys.method.Alg()}))
+
+	if err != nil || !token.Valid {
+		return nil, errors.New("Invalid token")
+	}
+
+	// Extract claims
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, errors.New("Invalid token claims")
+	}
+
+	return claims, nil
+}
+
 // generateToken creates a new JWT token
 func generateToken(userID uint) (string, error) {
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return signClaims(claims)
+}
+
+// generateImpersonationToken creates a short-lived token for userID flagged with the acting admin
+func generateImpersonationToken(userID, adminID uint) (string, time.Time, error) {
+	expiresAt := time.Now().Add(impersonationTTL)
+	claims := &Claims{
+		UserID:         userID,
+		ImpersonatedBy: adminID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(getJWTSecret()))
+	signed, err := signClaims(claims)
+	return signed, expiresAt, err
 }
 
 // getJWTSecret returns the JWT secret from environment or default
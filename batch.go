@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchSize caps the number of sub-requests in one batch
+const maxBatchSize = 20
+
+// BatchRequest is a list of API calls to run in order
+type BatchRequest struct {
+	Requests []BatchSubRequest `json:"requests" binding:"required,min=1,dive"`
+}
+
+// BatchSubRequest is one API call. ContentType defaults to the merge patch
+// type for PATCH and to JSON otherwise.
+type BatchSubRequest struct {
+	Method      string          `json:"method" binding:"required"`
+	Path        string          `json:"path" binding:"required"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+type BatchSubResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Paths that cannot run inside a batch: nesting and long-lived streams
+var batchExcludedPaths = []string{"/api/batch", "/api/tasks/stream", "/api/ws"}
+
+// batch returns a handler that replays sub-requests through the router with
+// the caller's credentials, stopping at the first authentication failure.
+// Other errors, including 403s for a single resource, do not stop the batch.
+func batch(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		if len(req.Requests) > maxBatchSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Batch is limited to %d requests", maxBatchSize)})
+			return
+		}
+
+		// Validate everything up front so a bad entry doesn't leave a half-run batch
+		for i, sub := range req.Requests {
+			sub.Method = strings.ToUpper(sub.Method)
+			switch sub.Method {
+			case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Request %d has an unsupported method", i)})
+				return
+			}
+			if !strings.HasPrefix(sub.Path, "/api/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Request %d must target an /api/ path", i)})
+				return
+			}
+			path := strings.SplitN(sub.Path, "?", 2)[0]
+			for _, excluded := range batchExcludedPaths {
+				if path == excluded {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Request %d cannot be batched", i)})
+					return
+				}
+			}
+			if sub.ContentType == "" {
+				sub.ContentType = "application/json"
+				if sub.Method == http.MethodPatch {
+					sub.ContentType = mergePatchContentType
+				}
+			}
+			req.Requests[i] = sub
+		}
+
+		responses := make([]BatchSubResponse, 0, len(req.Requests))
+		for _, sub := range req.Requests {
+			subReq, err := http.NewRequestWithContext(c.Request.Context(), sub.Method, sub.Path, bytes.NewReader(sub.Body))
+			if err != nil {
+				responses = append(responses, BatchSubResponse{Status: http.StatusBadRequest})
+				continue
+			}
+			// Sub-requests always run as the outer caller
+			subReq.Header.Set("Authorization", c.GetHeader("Authorization"))
+			if cookie := c.GetHeader("Cookie"); cookie != "" {
+				subReq.Header.Set("Cookie", cookie)
+				subReq.Header.Set(csrfHeaderName, c.GetHeader(csrfHeaderName))
+			}
+			if gatewayAuth() {
+				userHeader, emailHeader := gatewayHeaders()
+				subReq.Header.Set(userHeader, c.GetHeader(userHeader))
+				if email := c.GetHeader(emailHeader); email != "" {
+					subReq.Header.Set(emailHeader, email)
+				}
+			}
+			if len(sub.Body) > 0 {
+				subReq.Header.Set("Content-Type", sub.ContentType)
+			}
+			subReq.RemoteAddr = c.Request.RemoteAddr
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, subReq)
+
+			body := w.Body.Bytes()
+			if len(body) > 0 && !json.Valid(body) {
+				body, _ = json.Marshal(string(body))
+			}
+			responses = append(responses, BatchSubResponse{Status: w.Code, Body: body})
+
+			if w.Code == http.StatusUnauthorized {
+				break
+			}
+		}
+
+		c.JSON(http.StatusOK, responses)
+	}
+}
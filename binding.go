@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	ut "github.com/go-playground/universal-translator"
+)
+
+// bindJSON binds the request body and responds with a 400 describing why it
+// failed: a missing body, malformed JSON, or invalid field values, which are
+// listed per field in the client's language. A body declared as something
+// other than JSON gets a 415 instead.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	setupValidationTranslations()
+
+	if !isJSONContentType(c.ContentType()) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/json"})
+		return false
+	}
+
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body is required"})
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed JSON in request body"})
+	default:
+		if fields := validationFields(c, err); fields != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data", "fields": fields})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		}
+	}
+	return false
+}
+
+// isJSONContentType accepts application/json and +json types. A missing
+// Content-Type is let through since many clients omit it.
+func isJSONContentType(contentType string) bool {
+	return contentType == "" || contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// Default task field limits, overridable with TASK_TITLE_MAX_LENGTH,
+// TASK_DESCRIPTION_MAX_LENGTH and TASK_DESCRIPTION_MAX_BYTES
+const (
+	defaultTitleMaxLength       = 255
+	defaultDescriptionMaxLength = 10000
+	defaultDescriptionMaxBytes  = 64 * 1024
+)
+
+// taskColorPattern accepts #RRGGBB hex colors
+var taskColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// getEnvInt parses a positive integer environment variable, falling back to the default
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// sanitizeTitle strips control characters and collapses runs of whitespace,
+// including newlines and tabs, into single spaces
+func sanitizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, title)
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// sanitizeDescription strips control characters except newlines and tabs,
+// normalizing CRLF line endings to LF
+func sanitizeDescription(description string) string {
+	description = strings.ReplaceAll(description, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, description)
+}
+
+// UnmarshalJSON decodes a task request, noting whether a description was
+// present so createTask can tell an omitted one from an empty one
+func (r *TaskRequest) UnmarshalJSON(data []byte) error {
+	type plainTaskRequest TaskRequest
+	if err := json.Unmarshal(data, (*plainTaskRequest)(r)); err != nil {
+		return err
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	_, r.descriptionSet = keys["description"]
+	_, r.startDateSet = keys["start_date"]
+	return nil
+}
+
+// normalizeTaskTemplate sanitizes a task template and holds it to the same
+// limits as the descriptions it becomes
+func normalizeTaskTemplate(template string) (string, error) {
+	template = sanitizeDescription(template)
+
+	if maxBytes := currentFeatures().DescriptionMaxBytes; len(template) > maxBytes {
+		return "", fmt.Errorf("Task template must be at most %d bytes", maxBytes)
+	}
+	if descriptionMax := currentFeatures().DescriptionMaxLength; utf8.RuneCountInString(template) > descriptionMax {
+		return "", fmt.Errorf("Task template must be at most %d characters", descriptionMax)
+	}
+	return template, nil
+}
+
+// normalizeTaskRequest sanitizes the title and description and enforces
+// length limits, responding with 413 for an oversized description and
+// per-field errors when the request is otherwise invalid
+func normalizeTaskRequest(c *gin.Context, req *TaskRequest) bool {
+	return checkTaskRequest(c, req, http.StatusBadRequest)
+}
+
+// checkTaskRequest does the work of normalizeTaskRequest, reporting field
+// errors with invalidStatus
+func checkTaskRequest(c *gin.Context, req *TaskRequest, invalidStatus int) bool {
+	// Null bytes are refused outright rather than stripped; they only turn up
+	// in crafted input and would break CSV exports and logs downstream
+	titleHasNull := strings.ContainsRune(req.Title, 0)
+	req.Title = sanitizeTitle(req.Title)
+	req.Description = sanitizeDescription(req.Description)
+
+	// A hard cap on stored bytes, whatever the character count, so one row
+	// cannot bloat the database
+	if maxBytes := currentFeatures().DescriptionMaxBytes; len(req.Description) > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Description must be at most %d bytes", maxBytes)})
+		return false
+	}
+
+	// Only look up the client's language once there is something to say
+	var trans ut.Translator
+	message := func(key string, params ...string) string {
+		if trans == nil {
+			trans = requestTranslator(c)
+		}
+		return validationMessage(trans, key, params...)
+	}
+
+	fields := gin.H{}
+	titleMax := currentFeatures().TitleMaxLength
+	descriptionMax := currentFeatures().DescriptionMaxLength
+
+	if titleHasNull {
+		fields["title"] = message("title_null_bytes")
+	} else if req.Title == "" {
+		fields["title"] = message("title_required")
+	} else if utf8.RuneCountInString(req.Title) > titleMax {
+		fields["title"] = message("title_too_long", strconv.Itoa(titleMax))
+	}
+	if utf8.RuneCountInString(req.Description) > descriptionMax {
+		fields["description"] = message("description_too_long", strconv.Itoa(descriptionMax))
+	}
+	if req.Color != nil && *req.Color != "" && !taskColorPattern.MatchString(*req.Color) {
+		fields["color"] = message("color_invalid")
+	}
+
+	if len(fields) > 0 {
+		c.JSON(invalidStatus, gin.H{"error": "Invalid request data", "fields": fields})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultBreachAPI is HaveIBeenPwned's k-anonymity range endpoint
+const defaultBreachAPI = "https://api.pwnedpasswords.com/range/"
+
+// breachCheckTimeout bounds the lookup so an outage cannot stall signups
+const breachCheckTimeout = 3 * time.Second
+
+var breachClient = &http.Client{Timeout: breachCheckTimeout}
+
+// passwordBreached looks the password up in the breach corpus. Only the first
+// five hex characters of its SHA-1 leave the server; the suffix is matched
+// against the returned range locally.
+func passwordBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	ctx, cancel := context.WithTimeout(ctx, breachCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getEnv("PASSWORD_BREACH_API", defaultBreachAPI)+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padded responses hide which prefix was asked for from anyone watching sizes
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := breachClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breach API returned %s", resp.Status)
+	}
+
+	// Each line is SUFFIX:COUNT; padding entries have a count of 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && strings.EqualFold(candidate, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// rejectBreachedPassword responds with 400 when the check is enabled and the
+// password is known to be breached. Lookup failures let the password through
+// so an outage of the breach API never blocks signups.
+func rejectBreachedPassword(c *gin.Context, password string) bool {
+	if !currentFeatures().PasswordBreachCheck {
+		return false
+	}
+
+	breached, err := passwordBreached(c.Request.Context(), password)
+	if err != nil {
+		slog.Warn("Password breach check failed, allowing password", "error", err)
+		return false
+	}
+	if !breached {
+		return false
+	}
+
+	message := "This password has appeared in a known data breach, please choose a different one"
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "fields": gin.H{"password": message}})
+	return true
+}
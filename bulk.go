@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// maxBulkTaskIDs caps the number of tasks one bulk request may touch
+const maxBulkTaskIDs = 500
+
+// BulkPriorityRequest sets one priority on many tasks
+type BulkPriorityRequest struct {
+	IDs      []TaskRef `json:"ids" binding:"required,min=1"`
+	Priority string    `json:"priority" binding:"required,oneof=low medium high"`
+}
+
+// bulkUpdatePriority sets the priority of several tasks in a single query.
+// IDs the user does not own, or that do not exist, are skipped and counted
+// rather than failing the request.
+func bulkUpdatePriority(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req BulkPriorityRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if len(req.IDs) > maxBulkTaskIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d task IDs can be updated at once", maxBulkTaskIDs)})
+		return
+	}
+
+	taskIDs, ok := resolveTaskRefs(c, req.IDs)
+	if !ok {
+		return
+	}
+	requested := make(map[TaskRef]bool, len(req.IDs))
+	for _, ref := range req.IDs {
+		requested[ref] = true
+	}
+
+	var tasks []Task
+	result := db.WithContext(c.Request.Context()).Clauses(clause.Returning{}).Model(&tasks).
+		Where("user_id = ? AND id IN ?", userID, taskIDs).
+		Updates(map[string]interface{}{
+			"priority":   req.Priority,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		respondDBError(c, result.Error, "Failed to update task priorities")
+		return
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	for _, task := range tasks {
+		events.publish(TaskUpdated, task)
+	}
+	ids := taskPublicIDs(tasks)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Task priorities updated",
+		"priority": req.Priority,
+		"ids":      ids,
+		"affected": result.RowsAffected,
+		"skipped":  len(requested) - len(ids),
+	})
+}
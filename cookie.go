@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authCookieName holds the JWT when AUTH_MODE=cookie
+const authCookieName = "auth_token"
+
+// cookieAuth reports whether browser clients get their token in an HttpOnly
+// cookie rather than the login response body (AUTH_MODE=cookie)
+func cookieAuth() bool {
+	return currentFeatures().AuthMode == "cookie"
+}
+
+// setAuthCookie stores the token in a cookie scripts cannot read, that is
+// only sent over HTTPS and never on cross-site requests
+func setAuthCookie(c *gin.Context, token string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     authCookieName,
+		Value:    token,
+		Path:     "/api",
+		MaxAge:   int(tokenTTL / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearAuthCookie expires the auth cookie
+func clearAuthCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     authCookieName,
+		Value:    "",
+		Path:     "/api",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// logout clears the auth cookie; bearer clients simply discard their token
+func logout(c *gin.Context) {
+	clearAuthCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Double-submit CSRF cookie and the header that must echo it
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// issueCSRFToken sets a fresh CSRF cookie and returns its value. The cookie
+// is readable by page scripts so they can copy it into the header.
+func issueCSRFToken(c *gin.Context) (string, error) {
+	token, err := generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(tokenTTL / time.Second),
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// getCSRFToken hands out a new CSRF token for cookie-authenticated clients
+func getCSRFToken(c *gin.Context) {
+	token, err := issueCSRFToken(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSRF token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"csrf_token": token})
+}
+
+// csrfMiddleware enforces double-submit CSRF protection on state-changing
+// requests authenticated by the auth cookie. Bearer requests are exempt since
+// browsers never attach the header on their own.
+func csrfMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if !cookieAuth() || c.GetHeader("Authorization") != "" {
+			c.Next()
+			return
+		}
+		if authCookie, err := c.Cookie(authCookieName); err != nil || authCookie == "" {
+			c.Next()
+			return
+		}
+
+		cookie, err := c.Cookie(csrfCookieName)
+		header := c.GetHeader(csrfHeaderName)
+		if err != nil || cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or missing CSRF token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// dataExportBatch is how many tasks are loaded at a time while exporting
+const dataExportBatch = 500
+
+// dataExportVersion is bumped whenever the archive layout changes
+const dataExportVersion = 1
+
+// dataExportFile describes one file of the archive in its manifest
+type dataExportFile struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+}
+
+// dataExportManifest is written last, once the record counts are known
+type dataExportManifest struct {
+	Version    int              `json:"version"`
+	UserID     uint             `json:"user_id"`
+	Username   string           `json:"username"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Files      []dataExportFile `json:"files"`
+}
+
+// exportUserData downloads everything stored for the user as a ZIP with
+// profile.json, tasks.json (archived tasks included, with their
+// dependencies) and manifest.json. The archive is written straight to the
+// response a batch of tasks at a time, so large accounts are never held in
+// memory. Once streaming has started a failure can only cut the download
+// short, which the client sees as a corrupt archive.
+func exportUserData(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).First(&user, userID).Error; err != nil {
+		respondDBError(c, err, "Failed to export data")
+		return
+	}
+
+	exportedAt := time.Now().UTC()
+	filename := fmt.Sprintf("%s-export-%s.zip", user.Username, exportedAt.Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	manifest := dataExportManifest{
+		Version:    dataExportVersion,
+		UserID:     user.ID,
+		Username:   user.Username,
+		ExportedAt: exportedAt,
+	}
+
+	archive := zip.NewWriter(c.Writer)
+	err := writeExportJSON(archive, "profile.json", profileResponse(user))
+	if err == nil {
+		manifest.Files = append(manifest.Files, dataExportFile{Name: "profile.json", Records: 1})
+
+		var count int
+		count, err = writeExportTasks(c, archive, userID)
+		manifest.Files = append(manifest.Files, dataExportFile{Name: "tasks.json", Records: count})
+	}
+	if err == nil {
+		err = writeExportJSON(archive, "manifest.json", manifest)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		slog.Error("Failed to export user data", "user_id", userID, "error", err)
+		c.Abort()
+	}
+}
+
+// writeExportJSON adds a single indented JSON document to the archive
+func writeExportJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+// writeExportTasks streams the user's tasks into tasks.json as a JSON array,
+// returning how many were written
+func writeExportTasks(c *gin.Context, archive *zip.Writer, userID uint) (int, error) {
+	w, err := archive.Create("tasks.json")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var tasks []Task
+	result := db.WithContext(c.Request.Context()).Where("user_id = ?", userID).Order("id ASC").
+		FindInBatches(&tasks, dataExportBatch, func(tx *gorm.DB, batch int) error {
+			if err := loadTaskDependencies(c.Request.Context(), tasks); err != nil {
+				return err
+			}
+			for _, task := range tasks {
+				separator := ",\n"
+				if count == 0 {
+					separator = "\n"
+				}
+				data, err := json.Marshal(task)
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(w, separator); err != nil {
+					return err
+				}
+				if _, err := w.Write(data); err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return count, result.Error
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return count, err
+}
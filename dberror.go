@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// dbRetryAfter is the backoff suggested while the database is unreachable
+const dbRetryAfter = 5 * time.Second
+
+// checkViolationMessages turns CHECK constraint failures into client errors
+var checkViolationMessages = map[string]string{
+	"chk_tasks_priority":         "Invalid priority, must be low, medium or high",
+	"chk_tasks_estimate_minutes": "Estimate must not be negative",
+	"chk_tasks_actual_minutes":   "Actual time must not be negative",
+}
+
+// isDBUnavailable reports whether err means the database could not be
+// reached, as opposed to a query that reached it and failed
+func isDBUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// Class 08 is connection exceptions; 57P01-57P03 are server shutdown and startup
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+
+	// Failed dials and dropped connections surface as network errors
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// pgx marks errors where the query never reached the server as safe to retry
+	return pgconn.SafeToRetry(err)
+}
+
+// respondDBError answers a failed database call with 503 and Retry-After when
+// the database is unreachable, 400 when a CHECK constraint rejected the
+// data, or a 500 with message for any other failure
+func respondDBError(c *gin.Context, err error, message string) {
+	if isDBUnavailable(err) {
+		setRetryAfter(c.Writer.Header(), dbRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable, please retry shortly"})
+		return
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23514" {
+		invalid, ok := checkViolationMessages[pgErr.ConstraintName]
+		if !ok {
+			invalid = "Invalid field value"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TaskDependency records that TaskID cannot be completed before DependsOnID
+type TaskDependency struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	TaskID      uint      `json:"task_id" gorm:"not null;uniqueIndex:idx_task_dependencies_pair,priority:1"`
+	DependsOnID uint      `json:"depends_on_id" gorm:"not null;uniqueIndex:idx_task_dependencies_pair,priority:2;index"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type DependencyRequest struct {
+	DependsOnID TaskRef `json:"depends_on_id"`
+}
+
+// errDependencyCycle is returned when a new dependency would close a loop
+var errDependencyCycle = errors.New("dependency cycle")
+
+// loadTaskDependencies fills in DependsOn for each task
+func loadTaskDependencies(ctx context.Context, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+
+	var deps []struct {
+		TaskID        uint
+		DependsOnID   uint
+		DependsOnUUID string
+	}
+	if err := db.WithContext(ctx).Model(&TaskDependency{}).
+		Select("task_dependencies.task_id, task_dependencies.depends_on_id, tasks.uuid AS depends_on_uuid").
+		Joins("JOIN tasks ON tasks.id = task_dependencies.depends_on_id").
+		Where("task_dependencies.task_id IN ?", ids).
+		Order("task_dependencies.depends_on_id ASC").
+		Scan(&deps).Error; err != nil {
+		return err
+	}
+
+	byTask := map[uint][]uint{}
+	uuidsByTask := map[uint][]string{}
+	for _, dep := range deps {
+		byTask[dep.TaskID] = append(byTask[dep.TaskID], dep.DependsOnID)
+		uuidsByTask[dep.TaskID] = append(uuidsByTask[dep.TaskID], dep.DependsOnUUID)
+	}
+	for i := range tasks {
+		tasks[i].DependsOn = byTask[tasks[i].ID]
+		tasks[i].dependsOnUUIDs = uuidsByTask[tasks[i].ID]
+	}
+	return nil
+}
+
+// incompleteDependencies returns the tasks that still block taskID
+func incompleteDependencies(ctx context.Context, taskID uint) ([]Task, error) {
+	var tasks []Task
+	err := db.WithContext(ctx).Model(&Task{}).
+		Select("tasks.id", "tasks.uuid").
+		Joins("JOIN task_dependencies ON task_dependencies.depends_on_id = tasks.id").
+		Where("task_dependencies.task_id = ? AND tasks.completed = ?", taskID, false).
+		Order("tasks.id ASC").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+// checkCompletionAllowed responds with 409 and the blocking task IDs when a
+// request would complete a task whose dependencies are still open
+func checkCompletionAllowed(c *gin.Context, task Task, req TaskRequest) bool {
+	if req.Completed == nil || !*req.Completed || task.Completed {
+		return true
+	}
+
+	blocking, err := incompleteDependencies(c.Request.Context(), task.ID)
+	if err != nil {
+		respondDBError(c, err, "Failed to update task")
+		return false
+	}
+	if len(blocking) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Task has incomplete dependencies",
+			"blocked_by": taskPublicIDs(blocking),
+		})
+		return false
+	}
+	return true
+}
+
+// deleteTaskDependencies drops every dependency to or from the given tasks
+func deleteTaskDependencies(ctx context.Context, taskIDs []uint) error {
+	if len(taskIDs) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).
+		Where("task_id IN ? OR depends_on_id IN ?", taskIDs, taskIDs).
+		Delete(&TaskDependency{}).Error
+}
+
+// createsCycle reports whether taskID is already reachable from dependsOnID,
+// in which case adding taskID -> dependsOnID would close a loop. The walk
+// runs in the database so only the reachable part of the graph is visited.
+func createsCycle(tx *gorm.DB, userID, taskID, dependsOnID uint) (bool, error) {
+	var count int64
+	err := tx.Raw(`WITH RECURSIVE reachable(id) AS (
+		SELECT CAST(? AS BIGINT)
+		UNION
+		SELECT task_dependencies.depends_on_id FROM task_dependencies
+		JOIN reachable ON task_dependencies.task_id = reachable.id
+		WHERE task_dependencies.user_id = ?
+	)
+	SELECT COUNT(*) FROM reachable WHERE id = ?`, dependsOnID, userID, taskID).Scan(&count).Error
+	return count > 0, err
+}
+
+// addTaskDependency makes a task depend on another of the user's tasks
+func addTaskDependency(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req DependencyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.DependsOnID == (TaskRef{}) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "depends_on_id is required"})
+		return
+	}
+	resolved, ok := resolveTaskRefs(c, []TaskRef{req.DependsOnID})
+	if !ok {
+		return
+	}
+	dependsOnID := resolved[0]
+	if dependsOnID == taskID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A task cannot depend on itself"})
+		return
+	}
+
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
+		return
+	}
+
+	var dependsOn Task
+	if err := db.WithContext(c.Request.Context()).Where("id = ? AND user_id = ?", dependsOnID, userID).First(&dependsOn).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dependency task not found"})
+			return
+		}
+		respondDBError(c, err, "Failed to add dependency")
+		return
+	}
+
+	// Check and insert under a lock on the user's row. Without it two adds at
+	// READ COMMITTED could each pass the cycle check and then both insert.
+	err := db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, userID).Error; err != nil {
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&TaskDependency{}).Where("task_id = ? AND depends_on_id = ?", taskID, dependsOnID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return gorm.ErrDuplicatedKey
+		}
+
+		cycle, err := createsCycle(tx, userID, taskID, dependsOnID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return errDependencyCycle
+		}
+
+		dep := TaskDependency{TaskID: taskID, DependsOnID: dependsOnID, UserID: userID}
+		if err := tx.Create(&dep).Error; err != nil {
+			return err
+		}
+		return tx.Model(&task).Update("updated_at", time.Now()).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "Dependency already exists"})
+		return
+	case errors.Is(err, errDependencyCycle):
+		c.JSON(http.StatusConflict, gin.H{"error": "Dependency would create a cycle"})
+		return
+	case err != nil:
+		respondDBError(c, err, "Failed to add dependency")
+		return
+	}
+
+	respondTaskWithDependencies(c, http.StatusCreated, task)
+}
+
+// removeTaskDependency drops a single dependency from a task
+func removeTaskDependency(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
+		return
+	}
+	dependsOnID, ok := parseTaskID(c, "depends_on_id")
+	if !ok {
+		return
+	}
+
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
+		return
+	}
+
+	result := db.WithContext(c.Request.Context()).
+		Where("task_id = ? AND depends_on_id = ?", taskID, dependsOnID).
+		Delete(&TaskDependency{})
+	if result.Error != nil {
+		respondDBError(c, result.Error, "Failed to remove dependency")
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dependency not found"})
+		return
+	}
+
+	if err := db.WithContext(c.Request.Context()).Model(&task).Update("updated_at", time.Now()).Error; err != nil {
+		respondDBError(c, err, "Failed to remove dependency")
+		return
+	}
+
+	respondTaskWithDependencies(c, http.StatusOK, task)
+}
+
+// respondTaskWithDependencies publishes the change and returns the task with
+// its current dependencies
+func respondTaskWithDependencies(c *gin.Context, status int, task Task) {
+	tasks := []Task{task}
+	if err := loadTaskDependencies(c.Request.Context(), tasks); err != nil {
+		respondDBError(c, err, "Failed to load dependencies")
+		return
+	}
+	events.publish(TaskUpdated, tasks[0])
+	c.JSON(status, tasks[0])
+}
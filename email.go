@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// emailChangeTTL is how long an email change confirmation link stays valid
+const emailChangeTTL = 24 * time.Hour
+
+// startEmailChange records email as the user's pending address and returns
+// the confirmation token to send to it
+func startEmailChange(user *User, email string) (string, error) {
+	token, err := generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(emailChangeTTL)
+	user.PendingEmail = email
+	user.EmailChangeToken = token
+	user.EmailChangeExpiresAt = &expiresAt
+
+	return token, nil
+}
+
+// clearEmailChange drops any pending email change
+func clearEmailChange(user *User) {
+	user.PendingEmail = ""
+	user.EmailChangeToken = ""
+	user.EmailChangeExpiresAt = nil
+}
+
+// sendEmailChangeConfirmation delivers the confirmation link; there is no
+// mailer yet so the link is logged
+func sendEmailChangeConfirmation(user User, token string) {
+	slog.Info("Email change confirmation",
+		"user_id", user.ID,
+		"email", user.PendingEmail,
+		"confirm_url", "/api/confirm-email-change?token="+token,
+	)
+}
+
+// confirmEmailChange applies a pending email change from its confirmation
+// link. It is a GET but writes, so it is refused during maintenance.
+func confirmEmailChange(c *gin.Context) {
+	if rejectDuringMaintenance(c) {
+		return
+	}
+
+	token := strings.TrimSpace(c.Query("token"))
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation token is required"})
+		return
+	}
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).Where("email_change_token = ?", token).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation token"})
+		return
+	}
+
+	if user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation token"})
+		return
+	}
+
+	// Someone may have registered the address since the change was requested
+	var existingUser User
+	if err := db.WithContext(c.Request.Context()).Where("LOWER(email) = ? AND id <> ?", user.PendingEmail, user.ID).First(&existingUser).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists", "fields": gin.H{"email": "Email already exists"}})
+		return
+	}
+
+	user.Email = user.PendingEmail
+	clearEmailChange(&user)
+	user.UpdatedAt = time.Now()
+
+	if err := db.WithContext(c.Request.Context()).Save(&user).Error; err != nil {
+		respondDBError(c, err, "Failed to update email")
+		return
+	}
+
+	slog.Info("Email change confirmed", "user_id", user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email address updated",
+		"email":   user.Email,
+	})
+}
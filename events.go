@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Task event types
+const (
+	TaskCreated = "task.created"
+	TaskUpdated = "task.updated"
+	TaskDeleted = "task.deleted"
+)
+
+// keepaliveInterval is how often an idle stream receives a comment line
+const keepaliveInterval = 15 * time.Second
+
+// TaskEvent describes a change to one of a user's tasks
+type TaskEvent struct {
+	Type   string `json:"type"`
+	UserID uint   `json:"-"`
+	Task   Task   `json:"task"`
+}
+
+// eventHub fans task events out to the subscribers of each user
+type eventHub struct {
+	mu          sync.RWMutex
+	subscribers map[uint]map[chan TaskEvent]struct{}
+	broadcast   chan TaskEvent
+}
+
+// Global event hub instance
+var events = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[uint]map[chan TaskEvent]struct{}),
+		broadcast:   make(chan TaskEvent, 256),
+	}
+}
+
+// run delivers broadcast events until the broadcast channel is closed
+func (h *eventHub) run() {
+	for event := range h.broadcast {
+		h.mu.RLock()
+		for ch := range h.subscribers[event.UserID] {
+			select {
+			case ch <- event:
+			default:
+				// Slow subscriber, drop the event rather than block everyone
+			}
+		}
+		h.mu.RUnlock()
+	}
+}
+
+// subscribe registers a new event channel for the given user
+func (h *eventHub) subscribe(userID uint) chan TaskEvent {
+	ch := make(chan TaskEvent, 16)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan TaskEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+
+	return ch
+}
+
+// unsubscribe removes and closes a channel returned by subscribe
+func (h *eventHub) unsubscribe(userID uint, ch chan TaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if subs, ok := h.subscribers[userID]; ok {
+		if _, ok := subs[ch]; ok {
+			delete(subs, ch)
+			close(ch)
+		}
+		if len(subs) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+// publish queues an event for delivery without blocking the caller
+func (h *eventHub) publish(eventType string, task Task) {
+	event := TaskEvent{Type: eventType, UserID: task.UserID, Task: task}
+
+	select {
+	case h.broadcast <- event:
+	default:
+		slog.Warn("Event queue full, dropping event", "type", eventType, "task_id", task.ID)
+	}
+}
+
+// streamTasks pushes the authenticated user's task events as server-sent events
+func streamTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	ch := events.subscribe(userID)
+	defer events.unsubscribe(userID, ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return false
+			}
+			return true
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskMarkdown renders a task as a markdown document
+func taskMarkdown(task Task) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", task.Title)
+
+	check := " "
+	if task.Completed {
+		check = "x"
+	}
+	fmt.Fprintf(&b, "- [%s] Completed\n", check)
+	fmt.Fprintf(&b, "- Priority: %s\n", task.Priority)
+	if task.StartDate != nil {
+		fmt.Fprintf(&b, "- Starts: %s\n", task.StartDate.Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(&b, "- Created: %s\n", task.CreatedAt.Format("2006-01-02 15:04"))
+
+	if description := strings.TrimSpace(task.Description); description != "" {
+		fmt.Fprintf(&b, "\n%s\n", description)
+	}
+
+	return b.String()
+}
+
+// exportTask downloads a single task as markdown or JSON
+func exportTask(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "md")
+	if format != "md" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, must be md or json"})
+		return
+	}
+
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("task-%v.%s", taskPublicID(task), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if format == "json" {
+		data, err := json.MarshalIndent(task, "", "  ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export task"})
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(taskMarkdown(task)))
+}
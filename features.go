@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Features are the optional behaviours and limits set by environment
+// variables. They are read once at startup and handlers check them through
+// currentFeatures rather than reading the environment themselves.
+type Features struct {
+	RegistrationOpen        bool `json:"registration_open"`         // REGISTRATION_OPEN
+	InviteOnly              bool `json:"invite_only"`               // INVITE_ONLY
+	EmailChangeConfirmation bool `json:"email_change_confirmation"` // EMAIL_CHANGE_CONFIRMATION
+	PasswordBreachCheck     bool `json:"password_breach_check"`     // PASSWORD_BREACH_CHECK
+	UniqueTaskTitles        bool `json:"unique_task_titles"`        // UNIQUE_TASK_TITLES
+	TaskOwnershipForbidden  bool `json:"task_ownership_forbidden"`  // TASK_OWNERSHIP_FORBIDDEN
+	PreloadHints            bool `json:"preload_hints"`             // PRELOAD_HINTS
+	PrettyJSON              bool `json:"pretty_json"`               // PRETTY_JSON
+	ServeStatic             bool `json:"serve_static"`              // SERVE_STATIC
+	UseUUIDIDs              bool `json:"use_uuid_ids"`              // USE_UUID_IDS
+
+	// Settings that take a value rather than on or off
+	AuthMode              string        `json:"auth_mode"`               // AUTH_MODE: bearer, cookie or gateway
+	MaxTasksPerUser       int           `json:"max_tasks_per_user"`      // MAX_TASKS_PER_USER, 0 for no cap
+	MaintenanceMode       bool          `json:"maintenance_mode"`        // MAINTENANCE_MODE, the mode at startup
+	MaintenanceRetryAfter time.Duration `json:"maintenance_retry_after"` // MAINTENANCE_RETRY_AFTER
+	TitleMaxLength        int           `json:"title_max_length"`        // TASK_TITLE_MAX_LENGTH
+	DescriptionMaxLength  int           `json:"description_max_length"`  // TASK_DESCRIPTION_MAX_LENGTH
+	DescriptionMaxBytes   int           `json:"description_max_bytes"`   // TASK_DESCRIPTION_MAX_BYTES
+	HashAlgo              string        `json:"hash_algo"`               // HASH_ALGO: bcrypt or argon2id
+	UserRateLimit         int           `json:"user_rate_limit"`         // USER_RATE_LIMIT, 0 to turn off
+	UserRateWindow        time.Duration `json:"user_rate_window"`        // USER_RATE_LIMIT_WINDOW
+	ReminderWindow        time.Duration `json:"reminder_window"`         // REMINDER_WINDOW
+	TrustedProxies        []string      `json:"trusted_proxies"`         // TRUSTED_PROXIES, IPs and CIDRs
+
+	// trustedProxyNets is TrustedProxies parsed once rather than per request
+	trustedProxyNets []*net.IPNet
+}
+
+// MarshalJSON lists durations as text such as "1m0s" rather than nanoseconds
+func (f Features) MarshalJSON() ([]byte, error) {
+	type plainFeatures Features
+	return json.Marshal(struct {
+		plainFeatures
+		MaintenanceRetryAfter string `json:"maintenance_retry_after"`
+		UserRateWindow        string `json:"user_rate_window"`
+		ReminderWindow        string `json:"reminder_window"`
+	}{
+		plainFeatures:         plainFeatures(f),
+		MaintenanceRetryAfter: f.MaintenanceRetryAfter.String(),
+		UserRateWindow:        f.UserRateWindow.String(),
+		ReminderWindow:        f.ReminderWindow.String(),
+	})
+}
+
+// Flags in effect, replaced as a whole by reloadFeatures
+var (
+	featuresMu sync.RWMutex
+	features   = loadFeatures()
+)
+
+// loadFeatures reads every flag from the environment
+func loadFeatures() Features {
+	proxies := splitTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	return Features{
+		RegistrationOpen:        getEnvBool("REGISTRATION_OPEN", true),
+		InviteOnly:              getEnvBool("INVITE_ONLY", false),
+		EmailChangeConfirmation: getEnvBool("EMAIL_CHANGE_CONFIRMATION", true),
+		PasswordBreachCheck:     getEnvBool("PASSWORD_BREACH_CHECK", false),
+		UniqueTaskTitles:        getEnvBool("UNIQUE_TASK_TITLES", false),
+		TaskOwnershipForbidden:  getEnvBool("TASK_OWNERSHIP_FORBIDDEN", false),
+		PreloadHints:            getEnvBool("PRELOAD_HINTS", false),
+		PrettyJSON:              getEnvBool("PRETTY_JSON", false),
+		ServeStatic:             getEnvBool("SERVE_STATIC", true),
+		UseUUIDIDs:              getEnvBool("USE_UUID_IDS", false),
+		AuthMode:                strings.ToLower(getEnv("AUTH_MODE", "bearer")),
+		MaxTasksPerUser:         getEnvInt("MAX_TASKS_PER_USER", 0),
+		MaintenanceMode:         getEnvBool("MAINTENANCE_MODE", false),
+		MaintenanceRetryAfter:   getEnvDuration("MAINTENANCE_RETRY_AFTER", defaultMaintenanceRetryAfter),
+		TitleMaxLength:          getEnvInt("TASK_TITLE_MAX_LENGTH", defaultTitleMaxLength),
+		DescriptionMaxLength:    getEnvInt("TASK_DESCRIPTION_MAX_LENGTH", defaultDescriptionMaxLength),
+		DescriptionMaxBytes:     getEnvInt("TASK_DESCRIPTION_MAX_BYTES", defaultDescriptionMaxBytes),
+		HashAlgo:                strings.ToLower(getEnv("HASH_ALGO", "bcrypt")),
+		UserRateLimit:           getUserRateLimit(),
+		UserRateWindow:          getEnvDuration("USER_RATE_LIMIT_WINDOW", defaultUserRateWindow),
+		ReminderWindow:          getEnvDuration("REMINDER_WINDOW", defaultReminderWindow),
+		TrustedProxies:          proxies,
+		trustedProxyNets:        parseTrustedProxies(proxies),
+	}
+}
+
+// getEnvDuration parses a positive duration such as "5m" from the
+// environment, falling back to the default
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// reloadFeatures re-reads the flags, for startup once .env is loaded
+func reloadFeatures() Features {
+	loaded := loadFeatures()
+
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	features = loaded
+	return loaded
+}
+
+// currentFeatures returns the flags in effect
+func currentFeatures() Features {
+	featuresMu.RLock()
+	defer featuresMu.RUnlock()
+	return features
+}
+
+// getFeatures lists which optional features are enabled
+func getFeatures(c *gin.Context) {
+	c.JSON(http.StatusOK, currentFeatures())
+}
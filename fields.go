@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskFieldValues maps each selectable task field to its value; the keys
+// double as the whitelist for ?fields= and match the task's column names
+var taskFieldValues = map[string]func(Task) interface{}{
+	"id":               func(t Task) interface{} { return taskPublicID(t) },
+	"title":            func(t Task) interface{} { return t.Title },
+	"description":      func(t Task) interface{} { return t.Description },
+	"completed":        func(t Task) interface{} { return t.Completed },
+	"completed_at":     func(t Task) interface{} { return t.CompletedAt },
+	"priority":         func(t Task) interface{} { return t.Priority },
+	"archived_at":      func(t Task) interface{} { return t.ArchivedAt },
+	"start_date":       func(t Task) interface{} { return t.StartDate },
+	"color":            func(t Task) interface{} { return t.Color },
+	"estimate_minutes": func(t Task) interface{} { return t.EstimateMinutes },
+	"actual_minutes":   func(t Task) interface{} { return t.ActualMinutes },
+	"uuid":             func(t Task) interface{} { return t.UUID },
+	"client_id":        func(t Task) interface{} { return t.ClientID },
+	"reminder_sent_at": func(t Task) interface{} { return t.ReminderSentAt },
+	"user_id":          func(t Task) interface{} { return t.UserID },
+	"created_at":       func(t Task) interface{} { return t.CreatedAt },
+	"updated_at":       func(t Task) interface{} { return t.UpdatedAt },
+}
+
+// parseTaskFields reads the ?fields= list, returning nil when the client
+// wants full tasks. id is always included.
+func parseTaskFields(c *gin.Context) ([]string, error) {
+	raw := strings.TrimSpace(c.Query("fields"))
+	if raw == "" {
+		return nil, nil
+	}
+
+	fields := []string{"id"}
+	seen := map[string]bool{"id": true}
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if _, ok := taskFieldValues[field]; !ok {
+			return nil, fmt.Errorf("Unknown field %q", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// selectTaskFields projects a task onto the requested fields
+func selectTaskFields(task Task, fields []string) gin.H {
+	result := gin.H{}
+	for _, field := range fields {
+		result[field] = taskFieldValues[field](task)
+	}
+	return result
+}
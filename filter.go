@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits that keep a filter expression cheap to parse and to run
+const (
+	maxFilterConditions = 20
+	maxFilterDepth      = 5
+)
+
+// filterFieldType decides which operators and values a field accepts
+type filterFieldType int
+
+const (
+	filterBool filterFieldType = iota
+	filterEnum
+	filterString
+	filterDate
+)
+
+type filterField struct {
+	column string
+	kind   filterFieldType
+	values []string // allowed values for filterEnum
+}
+
+// Fields that may appear in a task filter, mapped to their columns
+var taskFilterFields = map[string]filterField{
+	"completed":  {column: "completed", kind: filterBool},
+	"priority":   {column: "priority", kind: filterEnum, values: []string{"low", "medium", "high"}},
+	"title":      {column: "title", kind: filterString},
+	"start_date": {column: "start_date", kind: filterDate},
+	"created_at": {column: "created_at", kind: filterDate},
+	"updated_at": {column: "updated_at", kind: filterDate},
+}
+
+// Comparison operators written after the colon, longest first so >= wins over >
+var filterOperators = []struct {
+	token string
+	sql   string
+}{
+	{">=", ">="},
+	{"<=", "<="},
+	{"!=", "<>"},
+	{">", ">"},
+	{"<", "<"},
+}
+
+// filterParser turns an expression such as
+//
+//	completed:false AND (priority:high OR created_at:>=2024-01-01)
+//
+// into a parameterized SQL condition. Field names and operators come from
+// fixed tables, values are only ever passed as arguments.
+type filterParser struct {
+	tokens     []string
+	loc        *time.Location
+	pos        int
+	depth      int
+	conditions int
+	args       []interface{}
+}
+
+// parseTaskFilter parses a filter expression into a where clause and its
+// arguments; bare dates are taken as whole days in loc
+func parseTaskFilter(input string, loc *time.Location) (string, []interface{}, error) {
+	tokens, err := tokenizeFilter(input)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tokens) == 0 {
+		return "", nil, fmt.Errorf("Filter is empty")
+	}
+
+	p := &filterParser{tokens: tokens, loc: loc}
+	sql, err := p.parseOr()
+	if err != nil {
+		return "", nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return "", nil, fmt.Errorf("Unexpected %q in filter", p.tokens[p.pos])
+	}
+
+	return "(" + sql + ")", p.args, nil
+}
+
+// tokenizeFilter splits on whitespace and parentheses, keeping double-quoted
+// values together
+func tokenizeFilter(input string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case inQuotes:
+			current.WriteRune(r)
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("Unterminated quote in filter")
+	}
+	flush()
+
+	return tokens, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = left + " OR " + right
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (string, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return "", err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return "", err
+		}
+		left = left + " AND " + right
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseTerm() (string, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return "", fmt.Errorf("Filter ends unexpectedly")
+	case token == ")":
+		return "", fmt.Errorf("Unexpected ) in filter")
+	case strings.EqualFold(token, "AND") || strings.EqualFold(token, "OR"):
+		return "", fmt.Errorf("Unexpected %s in filter", strings.ToUpper(token))
+	case token == "(":
+		p.depth++
+		if p.depth > maxFilterDepth {
+			return "", fmt.Errorf("Filter is nested more than %d levels deep", maxFilterDepth)
+		}
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if p.peek() != ")" {
+			return "", fmt.Errorf("Missing ) in filter")
+		}
+		p.pos++
+		p.depth--
+		return "(" + inner + ")", nil
+	}
+
+	p.pos++
+	return p.parseCondition(token)
+}
+
+// parseCondition handles a single field:value or field:<op>value term
+func (p *filterParser) parseCondition(token string) (string, error) {
+	p.conditions++
+	if p.conditions > maxFilterConditions {
+		return "", fmt.Errorf("Filter has more than %d conditions", maxFilterConditions)
+	}
+
+	name, raw, found := strings.Cut(token, ":")
+	if !found {
+		return "", fmt.Errorf("Invalid filter term %q, expected field:value", token)
+	}
+
+	field, ok := taskFilterFields[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("Unknown filter field %q", name)
+	}
+
+	op, opToken := "=", ":"
+	for _, candidate := range filterOperators {
+		if strings.HasPrefix(raw, candidate.token) {
+			op, opToken = candidate.sql, candidate.token
+			raw = strings.TrimPrefix(raw, candidate.token)
+			break
+		}
+	}
+
+	value := raw
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	if value == "" {
+		return "", fmt.Errorf("Missing value for filter field %q", name)
+	}
+
+	if field.kind != filterDate && op != "=" && op != "<>" {
+		return "", fmt.Errorf("Operator %s is not supported for %s", opToken, name)
+	}
+
+	switch field.kind {
+	case filterBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("Invalid value %q for %s, must be true or false", value, name)
+		}
+		p.args = append(p.args, b)
+
+	case filterEnum:
+		value = strings.ToLower(value)
+		if !containsString(field.values, value) {
+			return "", fmt.Errorf("Invalid value %q for %s, must be one of %s", value, name, strings.Join(field.values, ", "))
+		}
+		p.args = append(p.args, value)
+
+	case filterString:
+		p.args = append(p.args, value)
+
+	case filterDate:
+		return p.dateCondition(field.column, op, name, value)
+	}
+
+	return fmt.Sprintf("%s %s ?", field.column, op), nil
+}
+
+// dateCondition compares against a date or timestamp; a bare date covers the whole day
+func (p *filterParser) dateCondition(column, op, name, value string) (string, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		p.args = append(p.args, t)
+		return fmt.Sprintf("%s %s ?", column, op), nil
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, p.loc)
+	if err != nil {
+		return "", fmt.Errorf("Invalid date %q for %s, use YYYY-MM-DD or RFC 3339", value, name)
+	}
+	next := day.AddDate(0, 0, 1)
+
+	switch op {
+	case "=":
+		p.args = append(p.args, day, next)
+		return fmt.Sprintf("(%s >= ? AND %s < ?)", column, column), nil
+	case "<>":
+		p.args = append(p.args, day, next)
+		return fmt.Sprintf("(%s < ? OR %s >= ?)", column, column), nil
+	case ">":
+		p.args = append(p.args, next)
+		return fmt.Sprintf("%s >= ?", column), nil
+	case "<=":
+		p.args = append(p.args, next)
+		return fmt.Sprintf("%s < ?", column), nil
+	default:
+		p.args = append(p.args, day)
+		return fmt.Sprintf("%s %s ?", column, op), nil
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Headers an SSO gateway sets in AUTH_MODE=gateway, overridable with
+// AUTH_GATEWAY_HEADER and AUTH_GATEWAY_EMAIL_HEADER
+const (
+	defaultGatewayUserHeader  = "X-Authenticated-User"
+	defaultGatewayEmailHeader = "X-Authenticated-Email"
+)
+
+// gatewayEmailDomain addresses users the gateway did not send an email for.
+// The .invalid TLD is reserved, so these can never receive mail.
+const gatewayEmailDomain = "gateway.invalid"
+
+// gatewayAuth reports whether identity comes from a header set by an SSO
+// gateway in front of the app rather than from a JWT (AUTH_MODE=gateway).
+//
+// Security: the app cannot verify that header itself, so it is only as
+// trustworthy as the network path. Gateway mode must sit behind a proxy that
+// authenticates every request and overwrites the header, and TRUSTED_PROXIES
+// must list that proxy: requests from any other peer are rejected, since
+// anyone able to reach the app directly could otherwise claim any username.
+// main refuses to start in gateway mode without TRUSTED_PROXIES.
+func gatewayAuth() bool {
+	return currentFeatures().AuthMode == "gateway"
+}
+
+// gatewayHeaders returns the user and email header names in effect
+func gatewayHeaders() (string, string) {
+	return getEnv("AUTH_GATEWAY_HEADER", defaultGatewayUserHeader), getEnv("AUTH_GATEWAY_EMAIL_HEADER", defaultGatewayEmailHeader)
+}
+
+// checkGatewayConfig fails when gateway mode is on without a trusted proxy
+func checkGatewayConfig() error {
+	if gatewayAuth() && len(currentFeatures().trustedProxyNets) == 0 {
+		return errors.New("AUTH_MODE=gateway requires TRUSTED_PROXIES")
+	}
+	return nil
+}
+
+// splitTrustedProxies splits TRUSTED_PROXIES into its non-empty entries
+func splitTrustedProxies(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseTrustedProxies parses trusted proxy entries, accepting single IPs and
+// CIDRs
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// fromTrustedProxy reports whether the direct peer is a configured proxy.
+// It looks at the socket address, never at forwarding headers.
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range currentFeatures().trustedProxyNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// gatewayUser resolves the user named by the gateway header, creating an
+// account on first sight. It responds with the error and returns false when
+// the request cannot be authenticated.
+func gatewayUser(c *gin.Context) (uint, bool) {
+	if !fromTrustedProxy(c) {
+		slog.Warn("Gateway auth request from untrusted peer", "remote_ip", c.RemoteIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Request did not come through the authentication gateway"})
+		return 0, false
+	}
+
+	userHeader, emailHeader := gatewayHeaders()
+	username := strings.TrimSpace(c.GetHeader(userHeader))
+	if username == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authenticated user header required"})
+		return 0, false
+	}
+	email := normalizeEmail(c.GetHeader(emailHeader))
+
+	user, err := findOrCreateGatewayUser(c.Request.Context(), username, email)
+	if err != nil {
+		slog.Error("Failed to resolve gateway user", "username", username, "error", err)
+		respondDBError(c, err, "Failed to resolve user")
+		return 0, false
+	}
+
+	if !user.Active {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return 0, false
+	}
+	return user.ID, true
+}
+
+// findOrCreateGatewayUser loads a user by username or provisions one with
+// the given, already normalized, email. The password is a random hash nobody
+// knows, so the account can only be used through the gateway unless a
+// password reset is done.
+func findOrCreateGatewayUser(ctx context.Context, username, email string) (User, error) {
+	var user User
+	err := db.WithContext(ctx).Select("id", "active").Where("username = ?", username).First(&user).Error
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return User{}, err
+	}
+
+	if email == "" {
+		email = fmt.Sprintf("%s@%s", username, gatewayEmailDomain)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return User{}, err
+	}
+	hash, err := hashPassword(hex.EncodeToString(secret))
+	if err != nil {
+		return User{}, err
+	}
+
+	user = User{Username: username, Email: email, Password: hash, Active: true}
+	if err := db.WithContext(ctx).Create(&user).Error; err != nil {
+		// A concurrent first request may have created the user already
+		var existing User
+		if findErr := db.WithContext(ctx).Select("id", "active").Where("username = ?", username).First(&existing).Error; findErr == nil {
+			return existing, nil
+		}
+		return User{}, err
+	}
+
+	slog.Info("Provisioned user from gateway", "user_id", user.ID, "username", username)
+	return user, nil
+}
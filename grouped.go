@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskGroupings maps each ?by= value to the bucket a task falls in and the
+// buckets that are always present, even when empty
+var taskGroupings = map[string]struct {
+	key     func(Task) string
+	buckets []string
+}{
+	"priority": {
+		key:     func(t Task) string { return t.Priority },
+		buckets: []string{"high", "medium", "low"},
+	},
+	"completed": {
+		key:     func(t Task) string { return strconv.FormatBool(t.Completed) },
+		buckets: []string{"false", "true"},
+	},
+}
+
+// getGroupedTasks returns the user's tasks bucketed by priority or completion,
+// honouring the same filters and sort as the task list
+func getGroupedTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	by := c.DefaultQuery("by", "priority")
+	grouping, ok := taskGroupings[by]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grouping, must be priority or completed"})
+		return
+	}
+
+	order, prefs, ok := taskListOrder(c, userID)
+	if !ok {
+		return
+	}
+
+	query, ok := taskListQuery(c, userID, prefs)
+	if !ok {
+		return
+	}
+
+	// One ordered query, bucketed in memory so each group keeps the sort
+	tasks := []Task{}
+	if err := query.Order(order).Find(&tasks).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch tasks")
+		return
+	}
+
+	groups := make(map[string][]Task, len(grouping.buckets))
+	for _, bucket := range grouping.buckets {
+		groups[bucket] = []Task{}
+	}
+	for _, task := range tasks {
+		key := grouping.key(task)
+		groups[key] = append(groups[key], task)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"by":     by,
+		"groups": groups,
+	})
+}
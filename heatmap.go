@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// heatmapDateLayout keys each day in the heatmap
+const heatmapDateLayout = "2006-01-02"
+
+// Years the heatmap accepts
+const (
+	minHeatmapYear = 1970
+	maxHeatmapYear = 9999
+)
+
+// heatmapDay is one row of the per-day completion count
+type heatmapDay struct {
+	Day   string
+	Count int
+}
+
+// backfillCompletedAt stamps tasks completed before completed_at existed
+// with their last update, the best guess available. Tasks completed since
+// always have it, so after the first run this matches nothing.
+func backfillCompletedAt(ctx context.Context) error {
+	result := db.WithContext(ctx).Model(&Task{}).
+		Where("completed = ? AND completed_at IS NULL", true).
+		UpdateColumn("completed_at", gorm.Expr("updated_at"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to backfill completed_at: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		slog.Info("Backfilled task completion times", "count", result.RowsAffected)
+	}
+	return nil
+}
+
+// getCompletionHeatmap counts the user's completed tasks per day of a year,
+// in their timezone, for a contribution-style heatmap. Every day of the year
+// is present so clients need not fill gaps. Days come from completed_at, so
+// later edits to a completed task do not move it.
+func getCompletionHeatmap(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).Select("id", "timezone").First(&user, userID).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch heatmap")
+		return
+	}
+	loc := userLocation(user)
+
+	year := time.Now().In(loc).Year()
+	if yearStr, ok := c.GetQuery("year"); ok {
+		var err error
+		if year, err = strconv.Atoi(yearStr); err != nil || year < minHeatmapYear || year > maxHeatmapYear {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+			return
+		}
+	}
+
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(1, 0, 0)
+
+	var rows []heatmapDay
+	if err := db.WithContext(c.Request.Context()).Model(&Task{}).
+		Select("TO_CHAR(completed_at AT TIME ZONE ?, 'YYYY-MM-DD') AS day, COUNT(*) AS count", loc.String()).
+		Where("user_id = ? AND completed = ?", userID, true).
+		Where("completed_at >= ? AND completed_at < ?", start, end).
+		Group("day").
+		Scan(&rows).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch heatmap")
+		return
+	}
+
+	days := make(map[string]int)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days[day.Format(heatmapDateLayout)] = 0
+	}
+	total := 0
+	for _, row := range rows {
+		days[row.Day] = row.Count
+		total += row.Count
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"year":     year,
+		"timezone": loc.String(),
+		"days":     days,
+		"total":    total,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	"golang.org/x/text/language"
+)
+
+// Locales validation messages are available in. The first is the fallback
+// for clients asking for anything else.
+var validationLocales = []language.Tag{language.English, language.Spanish}
+
+var validationMatcher = language.NewMatcher(validationLocales)
+
+// Translators are registered on gin's validator once, on first use
+var (
+	translationsOnce sync.Once
+	translators      *ut.UniversalTranslator
+)
+
+// Messages for checks done outside struct tags, by key and locale. {0} and
+// {1} are replaced with the message's parameters.
+var customValidationMessages = map[string]map[string]string{
+	"title_required": {
+		"en": "Title is required",
+		"es": "El título es obligatorio",
+	},
+	"title_null_bytes": {
+		"en": "Title must not contain null bytes",
+		"es": "El título no debe contener bytes nulos",
+	},
+	"title_too_long": {
+		"en": "Title must be at most {0} characters",
+		"es": "El título debe tener como máximo {0} caracteres",
+	},
+	"description_too_long": {
+		"en": "Description must be at most {0} characters",
+		"es": "La descripción debe tener como máximo {0} caracteres",
+	},
+	"color_invalid": {
+		"en": "Color must be a hex color like #1A2B3C",
+		"es": "El color debe ser un color hexadecimal como #1A2B3C",
+	},
+}
+
+// setupValidationTranslations registers English and Spanish messages for the
+// validator's built-in tags and our own checks, and makes field errors use
+// JSON names so they match what clients sent
+func setupValidationTranslations() {
+	translationsOnce.Do(func() {
+		english := en.New()
+		translators = ut.New(english, english, es.New())
+
+		validate, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+
+		enTrans, _ := translators.GetTranslator("en")
+		esTrans, _ := translators.GetTranslator("es")
+		en_translations.RegisterDefaultTranslations(validate, enTrans)
+		es_translations.RegisterDefaultTranslations(validate, esTrans)
+
+		for key, messages := range customValidationMessages {
+			enTrans.Add(key, messages["en"], false)
+			esTrans.Add(key, messages["es"], false)
+		}
+	})
+}
+
+// requestTranslator picks the translator best matching Accept-Language,
+// falling back to English, and records the choice in Content-Language
+func requestTranslator(c *gin.Context) ut.Translator {
+	setupValidationTranslations()
+
+	tags, _, _ := language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	_, index, _ := validationMatcher.Match(tags...)
+	locale, _ := validationLocales[index].Base()
+
+	trans, _ := translators.GetTranslator(locale.String())
+	c.Header("Content-Language", locale.String())
+	return trans
+}
+
+// validationMessage translates one of customValidationMessages
+func validationMessage(trans ut.Translator, key string, params ...string) string {
+	message, err := trans.T(key, params...)
+	if err != nil {
+		return customValidationMessages[key]["en"]
+	}
+	return message
+}
+
+// validationFields maps each failed field to a message in the client's
+// language, or returns nil if err is not a validation failure
+func validationFields(c *gin.Context, err error) gin.H {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+
+	trans := requestTranslator(c)
+	fields := gin.H{}
+	for _, fieldErr := range validationErrs {
+		fields[fieldErr.Field()] = fieldErr.Translate(trans)
+	}
+	return fields
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Invite allows one registration while INVITE_ONLY is enabled
+type Invite struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	Token     string     `json:"token" gorm:"uniqueIndex;not null"`
+	Email     string     `json:"email,omitempty"`
+	CreatedBy uint       `json:"created_by" gorm:"not null"`
+	Used      bool       `json:"used" gorm:"not null;default:false"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type InviteRequest struct {
+	// Email optionally restricts the invite to a single address
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
+// generateRandomToken returns a random hex token for single-use links
+func generateRandomToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createInvite issues a new invite token
+func createInvite(c *gin.Context) {
+	adminID := c.GetUint("user_id")
+
+	var req InviteRequest
+	// The body is optional, an empty one creates an unrestricted invite
+	if c.Request.ContentLength != 0 && !bindJSON(c, &req) {
+		return
+	}
+
+	token, err := generateRandomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invite"})
+		return
+	}
+
+	invite := Invite{
+		Token:     token,
+		Email:     normalizeEmail(req.Email),
+		CreatedBy: adminID,
+	}
+
+	if err := db.WithContext(c.Request.Context()).Create(&invite).Error; err != nil {
+		respondDBError(c, err, "Failed to create invite")
+		return
+	}
+
+	slog.Info("Invite created", "admin_id", adminID, "invite_id", invite.ID)
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// redeemInvite marks an invite used for the given email inside the
+// registration transaction, returning the HTTP status to use on failure
+func redeemInvite(tx *gorm.DB, token, email string) (int, error) {
+	if token == "" {
+		return http.StatusForbidden, errors.New("An invite token is required to register")
+	}
+
+	var invite Invite
+	if err := tx.Where("token = ? AND used = ?", token, false).First(&invite).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return http.StatusForbidden, errors.New("Invalid or already used invite token")
+		}
+		return http.StatusInternalServerError, errors.New("Failed to check invite")
+	}
+
+	if invite.Email != "" && invite.Email != email {
+		return http.StatusForbidden, errors.New("Invite token is for a different email address")
+	}
+
+	// Guard on used so two concurrent registrations can't share one invite
+	now := time.Now()
+	result := tx.Model(&Invite{}).
+		Where("id = ? AND used = ?", invite.ID, false).
+		Updates(map[string]interface{}{"used": true, "used_at": now})
+	if result.Error != nil {
+		return http.StatusInternalServerError, errors.New("Failed to redeem invite")
+	}
+	if result.RowsAffected == 0 {
+		return http.StatusForbidden, errors.New("Invalid or already used invite token")
+	}
+
+	return http.StatusOK, nil
+}
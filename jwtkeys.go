@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKeys holds the configured signing method and key material. Tokens are
+// signed with the current key and verified against it plus an optional
+// previous key, so keys can be rotated without logging everyone out.
+type jwtKeys struct {
+	method     jwt.SigningMethod
+	signKey    interface{}
+	verifyKeys []jwt.VerificationKey
+}
+
+// Parsed keys are cached until the relevant environment changes
+var (
+	jwtKeysMu     sync.Mutex
+	jwtKeysCache  *jwtKeys
+	jwtKeysSource string
+)
+
+// Environment variables that configure token signing
+var jwtKeyEnv = []string{
+	"JWT_ALGORITHM",
+	"JWT_SECRET", "JWT_PREVIOUS_SECRET",
+	"JWT_PRIVATE_KEY", "JWT_PRIVATE_KEY_FILE",
+	"JWT_PREVIOUS_PUBLIC_KEY", "JWT_PREVIOUS_PUBLIC_KEY_FILE",
+}
+
+// getJWTKeys returns the signing configuration from JWT_ALGORITHM (HS256 or RS256)
+func getJWTKeys() (*jwtKeys, error) {
+	var source strings.Builder
+	for _, key := range jwtKeyEnv {
+		source.WriteString(key + "=" + os.Getenv(key) + "\n")
+	}
+
+	jwtKeysMu.Lock()
+	defer jwtKeysMu.Unlock()
+
+	if jwtKeysCache != nil && jwtKeysSource == source.String() {
+		return jwtKeysCache, nil
+	}
+
+	keys, err := loadJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+	jwtKeysCache, jwtKeysSource = keys, source.String()
+	return keys, nil
+}
+
+func loadJWTKeys() (*jwtKeys, error) {
+	switch alg := strings.ToUpper(getEnv("JWT_ALGORITHM", "HS256")); alg {
+	case "HS256":
+		keys := &jwtKeys{
+			method:     jwt.SigningMethodHS256,
+			signKey:    []byte(getJWTSecret()),
+			verifyKeys: []jwt.VerificationKey{[]byte(getJWTSecret())},
+		}
+		if previous := os.Getenv("JWT_PREVIOUS_SECRET"); previous != "" {
+			keys.verifyKeys = append(keys.verifyKeys, []byte(previous))
+		}
+		return keys, nil
+
+	case "RS256":
+		pemData, err := readKeyMaterial("JWT_PRIVATE_KEY")
+		if err != nil {
+			return nil, err
+		}
+		if pemData == nil {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE is required for RS256")
+		}
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT private key: %w", err)
+		}
+
+		keys := &jwtKeys{
+			method:     jwt.SigningMethodRS256,
+			signKey:    privateKey,
+			verifyKeys: []jwt.VerificationKey{&privateKey.PublicKey},
+		}
+
+		previousPEM, err := readKeyMaterial("JWT_PREVIOUS_PUBLIC_KEY")
+		if err != nil {
+			return nil, err
+		}
+		if previousPEM != nil {
+			previous, err := jwt.ParseRSAPublicKeyFromPEM(previousPEM)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JWT previous public key: %w", err)
+			}
+			keys.verifyKeys = append(keys.verifyKeys, previous)
+		}
+		return keys, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %q, must be HS256 or RS256", alg)
+	}
+}
+
+// readKeyMaterial returns PEM data from the named variable, or from the file
+// named by its _FILE variant; nil means neither is set
+func readKeyMaterial(name string) ([]byte, error) {
+	if value := os.Getenv(name); value != "" {
+		// Allow keys squeezed onto one line with literal \n escapes
+		return []byte(strings.ReplaceAll(value, `\n`, "\n")), nil
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s_FILE: %w", name, err)
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+// signClaims signs claims with the configured algorithm and key
+func signClaims(claims jwt.Claims) (string, error) {
+	keys, err := getJWTKeys()
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(keys.method, claims).SignedString(keys.signKey)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// Active log level, set by initLogging
+var logLevel = slog.LevelInfo
+
+// parseLogLevel maps a LOG_LEVEL value to a slog level
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid LOG_LEVEL %q, must be debug, info, warn or error", value)
+}
+
+// initLogging configures the default logger from LOG_LEVEL and LOG_FORMAT.
+// The standard log package is routed through it at info level.
+func initLogging() error {
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q, must be text or json", format)
+	}
+
+	logLevel = level
+	slog.SetDefault(slog.New(handler))
+	log.Printf("Logging configured: level=%s", level)
+	return nil
+}
+
+// gormLogger sends GORM's output to the default logger at the severity of
+// each message: failed queries at error, slow queries at warn, and every
+// statement at debug when GORM is at its info level
+type gormLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+func (l gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l.level = level
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, format string, args ...interface{}) {
+	if l.level >= logger.Info {
+		l.log(ctx, slog.LevelInfo, format, args...)
+	}
+}
+
+func (l gormLogger) Warn(ctx context.Context, format string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		l.log(ctx, slog.LevelWarn, format, args...)
+	}
+}
+
+func (l gormLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	if l.level >= logger.Error {
+		l.log(ctx, slog.LevelError, format, args...)
+	}
+}
+
+func (l gormLogger) log(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	slog.Log(ctx, level, strings.TrimSpace(fmt.Sprintf(format, args...)), "source", "gorm", "caller", utils.FileWithLineNum())
+}
+
+// Trace logs a finished statement. Missing records are expected and never
+// reported as errors.
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		slog.Log(ctx, slog.LevelError, "Query failed", "source", "gorm", "error", err, "elapsed", elapsed, "rows", rows, "sql", sql, "caller", utils.FileWithLineNum())
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		slog.Log(ctx, slog.LevelWarn, "Slow query", "source", "gorm", "elapsed", elapsed, "threshold", l.slowThreshold, "rows", rows, "sql", sql, "caller", utils.FileWithLineNum())
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		slog.Log(ctx, slog.LevelDebug, "Query", "source", "gorm", "elapsed", elapsed, "rows", rows, "sql", sql, "caller", utils.FileWithLineNum())
+	}
+}
+
+// defaultSlowQueryThreshold matches GORM's own default
+const defaultSlowQueryThreshold = 200 * time.Millisecond
+
+// getSlowQueryThreshold reads DB_SLOW_QUERY_THRESHOLD as a duration such as "200ms"
+func getSlowQueryThreshold() time.Duration {
+	value := os.Getenv("DB_SLOW_QUERY_THRESHOLD")
+	if value == "" {
+		return defaultSlowQueryThreshold
+	}
+
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold < 0 {
+		slog.Warn("Invalid DB_SLOW_QUERY_THRESHOLD, using default", "value", value, "default", defaultSlowQueryThreshold)
+		return defaultSlowQueryThreshold
+	}
+	return threshold
+}
+
+// newGormLogger builds a GORM logger that follows LOG_LEVEL. Every SQL
+// statement is logged only at debug; slow queries are reported whatever the
+// level unless the threshold is zero.
+func newGormLogger() logger.Interface {
+	gormLevel := logger.Error
+	switch logLevel {
+	case slog.LevelDebug:
+		gormLevel = logger.Info
+	case slog.LevelInfo, slog.LevelWarn:
+		gormLevel = logger.Warn
+	}
+
+	slowThreshold := getSlowQueryThreshold()
+	if slowThreshold > 0 {
+		// GORM only reports slow queries at warn level or above
+		if gormLevel < logger.Warn {
+			gormLevel = logger.Warn
+		}
+		log.Printf("Slow query threshold: %v", slowThreshold)
+	} else {
+		log.Println("Slow query logging disabled")
+	}
+
+	return gormLogger{level: gormLevel, slowThreshold: slowThreshold}
+}
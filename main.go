@@ -1,69 +1,159 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	"gorm.io/gorm/clause"
 )
 
 // User model
 type User struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Username  string    `json:"username" gorm:"unique;not null"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Password  string    `json:"-" gorm:"not null"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Tasks     []Task    `json:"tasks,omitempty" gorm:"foreignKey:UserID"`
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	Username        string     `json:"username" gorm:"unique;not null"`
+	Email           string     `json:"email" gorm:"unique;not null"`
+	Password        string     `json:"-" gorm:"not null"`
+	Active          bool       `json:"active" gorm:"not null;default:true"`
+	IsAdmin         bool       `json:"is_admin" gorm:"not null;default:false"`
+	DefaultTaskSort string     `json:"default_task_sort" gorm:"not null;default:created_at_desc"`
+	Timezone        string     `json:"timezone" gorm:"not null;default:UTC"`
+	TaskTemplate    string     `json:"task_template"`
+	TokensRevokedAt *time.Time `json:"-"`
+	LastLoginAt     *time.Time `json:"last_login_at,omitempty"`
+	LastLoginIP     string     `json:"last_login_ip,omitempty"`
+	// An email change waits here until the new address is confirmed
+	PendingEmail         string     `json:"-"`
+	EmailChangeToken     string     `json:"-" gorm:"index"`
+	EmailChangeExpiresAt *time.Time `json:"-"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+	Tasks                []Task     `json:"tasks,omitempty" gorm:"foreignKey:UserID"`
+	// NotificationPrefs are stored as notify_* columns
+	NotificationPrefs NotificationPrefs `json:"notification_prefs" gorm:"embedded;embeddedPrefix:notify_"`
 }
 
 // Task model
 type Task struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	Title       string    `json:"title" gorm:"not null"`
-	Description string    `json:"description"`
-	Completed   bool      `json:"completed" gorm:"default:false"`
-	UserID      uint      `json:"user_id" gorm:"not null"`
-	User        User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Title       string     `json:"title" gorm:"not null"`
+	Description string     `json:"description"`
+	Completed   bool       `json:"completed" gorm:"default:false"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" gorm:"index"`
+	Priority    string     `json:"priority" gorm:"not null;default:medium;check:chk_tasks_priority,priority IN ('low', 'medium', 'high')"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty" gorm:"index"`
+	StartDate   *time.Time `json:"start_date"`
+	Color       string     `json:"color,omitempty" gorm:"size:7"`
+	// Effort in minutes; ActualMinutes only grows through the time endpoint
+	EstimateMinutes int `json:"estimate_minutes" gorm:"not null;default:0;check:chk_tasks_estimate_minutes,estimate_minutes >= 0"`
+	ActualMinutes   int `json:"actual_minutes" gorm:"not null;default:0;check:chk_tasks_actual_minutes,actual_minutes >= 0"`
+	// UUID identifies the task in paths when USE_UUID_IDS is on
+	UUID string `json:"uuid" gorm:"type:uuid;uniqueIndex"`
+	// ClientID is an offline client's own identifier, unique per user
+	ClientID *string `json:"client_id,omitempty" gorm:"uniqueIndex:idx_tasks_user_client_id,priority:2"`
+	// ReminderSentAt is set by the notification worker to avoid repeats
+	ReminderSentAt *time.Time `json:"reminder_sent_at,omitempty"`
+	UserID         uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_tasks_user_client_id,priority:1"`
+	User           User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	// DependsOn lists the tasks that must be completed first, by ID and UUID
+	DependsOn      []uint `json:"depends_on,omitempty" gorm:"-"`
+	dependsOnUUIDs []string
 }
 
 // Request structs
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	// Identifier is a username or email; Username is kept for older clients
+	Identifier string `json:"identifier" binding:"required_without=Username"`
+	Username   string `json:"username"`
+	Password   string `json:"password" binding:"required"`
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3"`
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6"`
+	// InviteToken is required when INVITE_ONLY is enabled
+	InviteToken string `json:"invite_token"`
 }
 
 type TaskRequest struct {
 	Title       string `json:"title" binding:"required"`
 	Description string `json:"description"`
+	Priority    string `json:"priority" binding:"omitempty,oneof=low medium high"`
+	// StartDate is when work on the task can begin
+	StartDate *time.Time `json:"start_date"`
+	// ClientID makes create an upsert keyed on the client's own task ID
+	ClientID string `json:"client_id" binding:"omitempty,max=64"`
+	// Completed is left unchanged when omitted
+	Completed *bool `json:"completed"`
+	// Color is a #RRGGBB label; omitted leaves it unchanged, "" clears it
+	Color *string `json:"color"`
+	// EstimateMinutes is left unchanged when omitted
+	EstimateMinutes *int `json:"estimate_minutes" binding:"omitempty,min=0"`
+	// descriptionSet records whether the body had a description key at all,
+	// so an explicit "" can override the user's task template
+	descriptionSet bool
+	// startDateSet tells an omitted start_date, left unchanged, from null
+	startDateSet bool
 }
 
+type ProfileRequest struct {
+	DefaultTaskSort *string `json:"default_task_sort"`
+	// Timezone is an IANA zone name such as Europe/London
+	Timezone *string `json:"timezone"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+	// TaskTemplate is the default description for new tasks; "" removes it
+	TaskTemplate *string `json:"task_template"`
+}
+
+// Allowed task list sort orders
+var taskSortOrders = map[string]string{
+	"created_at_desc": "created_at DESC",
+	"created_at_asc":  "created_at ASC",
+	"updated_at_desc": "updated_at DESC",
+	"updated_at_asc":  "updated_at ASC",
+	"title_asc":       "LOWER(title) ASC",
+	"title_desc":      "LOWER(title) DESC",
+	// Rank high before medium before low, newest first within a priority
+	"priority": "CASE priority WHEN 'high' THEN 0 WHEN 'medium' THEN 1 ELSE 2 END, created_at DESC",
+}
+
+const defaultTaskSort = "created_at_desc"
+
 // Global database instance
 var db *gorm.DB
 
 func main() {
+	migrate := flag.Bool("migrate", false, "run database migrations and exit")
+	seed := flag.Bool("seed", false, "create a demo user with sample tasks and exit")
+	flag.Parse()
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using default values")
 	}
+	reloadFeatures()
+
+	// Configure logging
+	if err := initLogging(); err != nil {
+		log.Fatal("Failed to configure logging:", err)
+	}
 
 	// Debug: Log environment variables (without sensitive data)
 	log.Printf("Environment: PORT=%s, GIN_MODE=%s",
@@ -77,22 +167,99 @@ func main() {
 		log.Printf("No DATABASE_URL found")
 	}
 
+	// Fail fast on bad token signing configuration
+	if _, err := getJWTKeys(); err != nil {
+		log.Fatal("Invalid JWT configuration:", err)
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database
 	if err := initDB(); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
+	// Migrations run as part of initDB
+	if *migrate {
+		log.Println("Migrations complete")
+		return
+	}
+
+	// Seed demo data and exit
+	if *seed {
+		if gin.Mode() == gin.ReleaseMode {
+			log.Fatal("Refusing to seed demo data in release mode")
+		}
+		if err := seedDemoData(); err != nil {
+			log.Fatal("Failed to seed demo data:", err)
+		}
+		return
+	}
+
+	// Start task event delivery
+	go events.run()
+
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
 	// Create router
 	r := gin.Default()
 
+	// Only trust forwarded client IPs from configured proxies
+	if proxies := currentFeatures().TrustedProxies; len(proxies) > 0 {
+		if err := r.SetTrustedProxies(proxies); err != nil {
+			log.Fatal("Invalid TRUSTED_PROXIES:", err)
+		}
+		log.Printf("Trusted proxies: %s", strings.Join(proxies, ","))
+	}
+
+	// Gateway auth trusts a header, so it is only safe behind known proxies
+	if err := checkGatewayConfig(); err != nil {
+		log.Fatal("Invalid auth config:", err)
+	}
+
+	// Page sizes for paginated lists
+	if err := configurePagination(); err != nil {
+		log.Fatal("Invalid pagination config:", err)
+	}
+	log.Printf("Page size: default %d, max %d", defaultPageSize, maxPageSize)
+
+	// Shared store for per-user rate limits
+	if err := configureRateLimitStore(); err != nil {
+		log.Fatal("Invalid rate limit config:", err)
+	}
+	log.Printf("Rate limit backend: %s", getEnv("LIMITER_BACKEND", "memory"))
+
+	// Tracing middleware
+	r.Use(tracingMiddleware())
+
+	// Request timeout middleware
+	requestTimeout := getRequestTimeout()
+	log.Printf("Request timeout: %v", requestTimeout)
+	r.Use(timeoutMiddleware(requestTimeout))
+
+	// Optional indented JSON for manual debugging
+	r.Use(prettyJSONMiddleware())
+
+	// snake_case or camelCase response keys; runs inside pretty printing
+	jsonNaming, err := getJSONNaming()
+	if err != nil {
+		log.Fatal("Invalid JSON naming config:", err)
+	}
+	log.Printf("JSON naming: %s", jsonNaming)
+	r.Use(jsonNamingMiddleware(jsonNaming))
+
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-None-Match, If-Modified-Since")
+		c.Header("Access-Control-Expose-Headers", "X-Total-Count, X-Page, X-Per-Page, X-Next-Cursor, Link, ETag, Last-Modified, X-Impersonated-By, X-Sync-Token")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -102,37 +269,94 @@ func main() {
 		c.Next()
 	})
 
-	// Serve static files
-	r.Static("/static", "./static")
+	// Serve the web UI unless running as a headless API
+	if currentFeatures().ServeStatic {
+		log.Println("Serving static files and HTML templates")
 
-	// Load HTML templates
-	r.LoadHTMLGlob("templates/*")
+		// Serve static files
+		r.Static("/static", "./static")
 
-	// Routes
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"title": "CheckMate - Task Management",
+		// Load HTML templates
+		r.LoadHTMLGlob("templates/*")
+
+		// Routes
+		r.GET("/", func(c *gin.Context) {
+			setPreloadHints(c, indexPreloadHints()...)
+			c.HTML(http.StatusOK, "index.html", gin.H{
+				"title": "CheckMate - Task Management",
+			})
 		})
-	})
+	} else {
+		log.Println("SERVE_STATIC disabled, running as JSON API only")
+	}
 
 	// API routes
 	api := r.Group("/api")
+	api.Use(csrfMiddleware())
+	api.Use(maintenanceMiddleware())
 	{
 		// Public routes
 		api.POST("/register", register)
 		api.POST("/login", login)
+		api.POST("/logout", logout)
+		api.GET("/csrf-token", getCSRFToken)
+		api.GET("/ping", ping)
+		api.GET("/confirm-email-change", confirmEmailChange)
+		api.POST("/auth/validate", validateToken)
 
 		// Protected routes
 		protected := api.Group("/")
 		protected.Use(authMiddleware())
 		{
 			protected.GET("/tasks", getTasks)
+			protected.GET("/tasks/count", countTasks)
+			protected.GET("/tasks/grouped", getGroupedTasks)
+			protected.GET("/tasks/recent-completed", getRecentlyCompleted)
 			protected.POST("/tasks", createTask)
+			protected.GET("/tasks/stream", streamTasks)
+			protected.DELETE("/tasks/completed", userRateLimit(), clearCompletedTasks)
+			protected.POST("/tasks/bulk-priority", userRateLimit(), bulkUpdatePriority)
 			protected.GET("/tasks/:id", getTask)
+			protected.GET("/tasks/:id/export", userRateLimit(), exportTask)
+			protected.POST("/tasks/:id/time", logTaskTime)
+			protected.POST("/tasks/:id/dependencies", addTaskDependency)
+			protected.DELETE("/tasks/:id/dependencies/:depends_on_id", removeTaskDependency)
 			protected.PUT("/tasks/:id", updateTask)
+			protected.PATCH("/tasks/:id", patchTask)
 			protected.DELETE("/tasks/:id", deleteTask)
+			protected.POST("/batch", userRateLimit(), batch(r))
+			protected.GET("/search", userRateLimit(), search)
+			protected.GET("/stats/heatmap", getCompletionHeatmap)
+			protected.GET("/stats/time", getTimeStats)
+			protected.GET("/auth/whoami", whoami)
 			protected.GET("/profile", getProfile)
+			protected.PUT("/profile", updateProfile)
+			protected.GET("/profile/notifications", getNotificationPrefs)
+			protected.PUT("/profile/notifications", updateNotificationPrefs)
+			protected.GET("/profile/export", userRateLimit(), exportUserData)
+		}
+
+		// Admin routes
+		admin := api.Group("/admin")
+		admin.Use(authMiddleware(), adminMiddleware())
+		{
+			admin.GET("/users", listUsers)
+			admin.GET("/digest", getDigest)
+			admin.GET("/db-stats", getDBStats)
+			admin.GET("/reminders", getDueReminders)
+			admin.POST("/reminders/sent", markRemindersSent)
+			admin.POST("/impersonate/:user_id", impersonateUser)
+			admin.POST("/users/:id/deactivate", deactivateUser)
+			admin.POST("/users/:id/reactivate", reactivateUser)
+			admin.POST("/users/:id/transfer-tasks", transferTasks)
+			admin.POST("/invites", createInvite)
+			admin.GET("/maintenance", getMaintenance)
+			admin.GET("/features", getFeatures)
+			admin.PUT("/maintenance", updateMaintenance)
 		}
+
+		// WebSocket authenticates with a token query param
+		api.GET("/ws", taskSocket)
 	}
 
 	// Get port from environment or use default
@@ -153,10 +377,7 @@ func initDB() error {
 		log.Printf("Using DATABASE_URL for connection")
 
 		// Configure GORM logger
-		gormLogger := logger.Default.LogMode(logger.Info)
-		if gin.Mode() == gin.ReleaseMode {
-			gormLogger = logger.Default.LogMode(logger.Error)
-		}
+		gormLogger := newGormLogger()
 
 		// Retry connection with exponential backoff
 		maxRetries := 5
@@ -167,7 +388,7 @@ func initDB() error {
 				Logger: gormLogger,
 			})
 			if err != nil {
-				log.Printf("Database connection attempt %d failed: %v", i+1, err)
+				slog.Warn("Database connection attempt failed", "attempt", i+1, "error", err)
 				if i < maxRetries-1 {
 					waitTime := time.Duration(1<<uint(i)) * time.Second
 					log.Printf("Retrying in %v...", waitTime)
@@ -180,13 +401,13 @@ func initDB() error {
 			// Test the connection
 			sqlDB, err := db.DB()
 			if err != nil {
-				log.Printf("Failed to get underlying sql.DB: %v", err)
+				slog.Error("Failed to get underlying sql.DB", "error", err)
 				continue
 			}
 
 			// Ping the database
 			if err := sqlDB.Ping(); err != nil {
-				log.Printf("Database ping attempt %d failed: %v", i+1, err)
+				slog.Warn("Database ping attempt failed", "attempt", i+1, "error", err)
 				if i < maxRetries-1 {
 					waitTime := time.Duration(1<<uint(i)) * time.Second
 					log.Printf("Retrying ping in %v...", waitTime)
@@ -196,10 +417,21 @@ func initDB() error {
 				return fmt.Errorf("failed to ping database after %d attempts: %w", maxRetries, err)
 			}
 
+			// Trace database operations
+			if err := db.Use(tracingPlugin{}); err != nil {
+				return fmt.Errorf("failed to register tracing plugin: %w", err)
+			}
+
 			// Auto migrate schema
-			if err := db.AutoMigrate(&User{}, &Task{}); err != nil {
+			if err := db.AutoMigrate(&User{}, &Task{}, &Invite{}, &TaskTombstone{}, &TaskDependency{}); err != nil {
 				return fmt.Errorf("failed to migrate database: %w", err)
 			}
+			if err := backfillTaskUUIDs(context.Background()); err != nil {
+				return err
+			}
+			if err := backfillCompletedAt(context.Background()); err != nil {
+				return err
+			}
 
 			log.Println("Database connected and migrated successfully")
 			return nil
@@ -226,10 +458,7 @@ func initDB() error {
 		host, port, user, password, dbname, sslmode)
 
 	// Configure GORM logger
-	gormLogger := logger.Default.LogMode(logger.Info)
-	if gin.Mode() == gin.ReleaseMode {
-		gormLogger = logger.Default.LogMode(logger.Error)
-	}
+	gormLogger := newGormLogger()
 
 	// Retry connection with exponential backoff
 	maxRetries := 5
@@ -240,7 +469,7 @@ func initDB() error {
 			Logger: gormLogger,
 		})
 		if err != nil {
-			log.Printf("Database connection attempt %d failed: %v", i+1, err)
+			slog.Warn("Database connection attempt failed", "attempt", i+1, "error", err)
 			if i < maxRetries-1 {
 				// Wait before retry (exponential backoff: 1s, 2s, 4s, 8s, 16s)
 				waitTime := time.Duration(1<<uint(i)) * time.Second
@@ -254,13 +483,13 @@ func initDB() error {
 		// Test the connection
 		sqlDB, err := db.DB()
 		if err != nil {
-			log.Printf("Failed to get underlying sql.DB: %v", err)
+			slog.Error("Failed to get underlying sql.DB", "error", err)
 			continue
 		}
 
 		// Ping the database
 		if err := sqlDB.Ping(); err != nil {
-			log.Printf("Database ping attempt %d failed: %v", i+1, err)
+			slog.Warn("Database ping attempt failed", "attempt", i+1, "error", err)
 			if i < maxRetries-1 {
 				waitTime := time.Duration(1<<uint(i)) * time.Second
 				log.Printf("Retrying ping in %v...", waitTime)
@@ -270,10 +499,21 @@ func initDB() error {
 			return fmt.Errorf("failed to ping database after %d attempts: %w", maxRetries, err)
 		}
 
+		// Trace database operations
+		if err := db.Use(tracingPlugin{}); err != nil {
+			return fmt.Errorf("failed to register tracing plugin: %w", err)
+		}
+
 		// Auto migrate schema
-		if err := db.AutoMigrate(&User{}, &Task{}); err != nil {
+		if err := db.AutoMigrate(&User{}, &Task{}, &Invite{}, &TaskTombstone{}, &TaskDependency{}); err != nil {
 			return fmt.Errorf("failed to migrate database: %w", err)
 		}
+		if err := backfillTaskUUIDs(context.Background()); err != nil {
+			return err
+		}
+		if err := backfillCompletedAt(context.Background()); err != nil {
+			return err
+		}
 
 		log.Println("Database connected and migrated successfully")
 		return nil
@@ -289,33 +529,61 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// getEnvBool parses a boolean environment variable, falling back to the default
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
 }
 
-func checkPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// normalizeEmail trims and lower-cases an email for storage and lookup
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ping is a dependency-free liveness probe that never touches the database
+func ping(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"pong":      true,
+		"timestamp": time.Now().UTC(),
+	})
 }
 
 func register(c *gin.Context) {
+	if !currentFeatures().RegistrationOpen {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is closed, please contact an administrator for an account"})
+		return
+	}
+
 	var req RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindJSON(c, &req) {
 		return
 	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = normalizeEmail(req.Email)
 
-	// Check if username already exists
+	// Check both fields so the form can flag every conflicting input at once
+	conflicts := gin.H{}
+	message := ""
 	var existingUser User
-	if err := db.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+	if err := db.WithContext(c.Request.Context()).Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
+		message = "Username already exists"
+		conflicts["username"] = message
+	}
+	if err := db.WithContext(c.Request.Context()).Where("LOWER(email) = ?", req.Email).First(&existingUser).Error; err == nil {
+		conflicts["email"] = "Email already exists"
+		if message == "" {
+			message = "Email already exists"
+		}
+	}
+	if len(conflicts) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": message, "fields": conflicts})
 		return
 	}
 
-	// Check if email already exists
-	if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+	if rejectBreachedPassword(c, req.Password) {
 		return
 	}
 
@@ -331,35 +599,61 @@ func register(c *gin.Context) {
 		Username:  req.Username,
 		Email:     req.Email,
 		Password:  hashedPassword,
+		Active:    true,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	if err := db.Create(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+	// Create the user and redeem the invite together so neither happens alone
+	status := http.StatusInternalServerError
+	err = db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if currentFeatures().InviteOnly {
+			var inviteErr error
+			if status, inviteErr = redeemInvite(tx, req.InviteToken, req.Email); inviteErr != nil {
+				return inviteErr
+			}
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			status = http.StatusInternalServerError
+			return errors.New("Failed to create user")
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "User created successfully",
 		"user": gin.H{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
+			"id":         user.ID,
+			"username":   user.Username,
+			"email":      user.Email,
+			"created_at": user.CreatedAt,
+			"updated_at": user.UpdatedAt,
 		},
 	})
 }
 
 func login(c *gin.Context) {
 	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// Find user
+	identifier := req.Identifier
+	if identifier == "" {
+		identifier = req.Username
+	}
+
+	// Find user by username, falling back to email
 	var user User
-	if err := db.Where("username = ?", req.Username).First(&user).Error; err != nil {
+	err := db.WithContext(c.Request.Context()).Where("username = ?", strings.TrimSpace(identifier)).First(&user).Error
+	if err != nil && strings.Contains(identifier, "@") {
+		err = db.WithContext(c.Request.Context()).Where("LOWER(email) = ?", normalizeEmail(identifier)).First(&user).Error
+	}
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -370,6 +664,30 @@ func login(c *gin.Context) {
 		return
 	}
 
+	// Reject deactivated accounts
+	if !user.Active {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+
+	// Upgrade the stored hash if the configured algorithm or cost changed
+	if needsRehash(user.Password) {
+		if hashedPassword, err := hashPassword(req.Password); err != nil {
+			slog.Error("Failed to rehash password", "user_id", user.ID, "error", err)
+		} else if err := db.WithContext(c.Request.Context()).Model(&user).Update("password", hashedPassword).Error; err != nil {
+			slog.Error("Failed to save rehashed password", "user_id", user.ID, "error", err)
+		}
+	}
+
+	// Record the login; ClientIP honours TRUSTED_PROXIES
+	now := time.Now()
+	if err := db.WithContext(c.Request.Context()).Model(&user).Updates(map[string]interface{}{
+		"last_login_at": now,
+		"last_login_ip": c.ClientIP(),
+	}).Error; err != nil {
+		slog.Error("Failed to record login", "user_id", user.ID, "error", err)
+	}
+
 	// Generate token
 	token, err := generateToken(user.ID)
 	if err != nil {
@@ -377,148 +695,854 @@ func login(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Login successful",
-		"token":   token,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
 			"email":    user.Email,
 		},
-	})
+	}
+
+	// Cookie mode keeps the token out of reach of page scripts
+	if cookieAuth() {
+		setAuthCookie(c, token)
+		csrfToken, err := issueCSRFToken(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSRF token"})
+			return
+		}
+		response["csrf_token"] = csrfToken
+	} else {
+		response["token"] = token
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func getTasks(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
-	var tasks []Task
-	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tasks).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tasks"})
+	// Incremental sync has its own response rules
+	if _, ok := c.GetQuery("modified_since"); ok {
+		syncTasks(c)
+		return
+	}
+
+	pagination, paginate, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// ?cursor= (empty for the first page) switches to keyset paging
+	cursorStr, useCursor := c.GetQuery("cursor")
+	if useCursor {
+		if _, hasPage := c.GetQuery("page"); hasPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Use either cursor or page, not both"})
+			return
+		}
+		if !paginate {
+			pagination = Pagination{Page: 1, PerPage: defaultPageSize}
+		}
+		paginate = false
+	}
+
+	order, prefs, ok := taskListOrder(c, userID)
+	if !ok {
+		return
+	}
+
+	fields, err := parseTaskFields(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query, ok := taskListQuery(c, userID, prefs)
+	if !ok {
+		return
+	}
+
+	if paginate {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			respondDBError(c, err, "Failed to fetch tasks")
+			return
+		}
+		setPaginationHeaders(c, pagination, total)
+		query = query.Offset(pagination.Offset()).Limit(pagination.PerPage)
+	}
+
+	if useCursor {
+		// Keyset paging follows created_at, with id breaking ties
+		var descending bool
+		switch order {
+		case taskSortOrders["created_at_desc"]:
+			descending = true
+		case taskSortOrders["created_at_asc"]:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor pagination requires sort created_at_desc or created_at_asc"})
+			return
+		}
+
+		if cursorStr != "" {
+			cursor, err := decodeTaskCursor(cursorStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			op := ">"
+			if descending {
+				op = "<"
+			}
+			query = query.Where(fmt.Sprintf("created_at %[1]s ? OR (created_at = ? AND id %[1]s ?)", op),
+				cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+
+		if descending {
+			order += ", id DESC"
+		} else {
+			order += ", id ASC"
+		}
+		// One extra row tells whether there is a next page
+		query = query.Limit(pagination.PerPage + 1)
+	}
+
+	if fields != nil {
+		selected := fields
+		if useCursor && !containsString(fields, "created_at") {
+			selected = append(selected[:len(selected):len(selected)], "created_at")
+		}
+		query = query.Select(selected)
+	}
+
+	// Non-nil so an empty list encodes as [] rather than null
+	tasks := []Task{}
+	if err := query.Order(order).Find(&tasks).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch tasks")
+		return
+	}
+
+	next := ""
+	if useCursor {
+		if len(tasks) > pagination.PerPage {
+			tasks = tasks[:pagination.PerPage]
+			next = encodeTaskCursor(tasks[len(tasks)-1])
+		}
+		setCursorHeaders(c, pagination.PerPage, next)
+	}
+
+	var body interface{} = tasks
+	if fields != nil {
+		sparse := make([]gin.H, 0, len(tasks))
+		for _, task := range tasks {
+			sparse = append(sparse, selectTaskFields(task, fields))
+		}
+		body = sparse
+	} else if err := loadTaskDependencies(c.Request.Context(), tasks); err != nil {
+		respondDBError(c, err, "Failed to fetch tasks")
 		return
 	}
 
-	c.JSON(http.StatusOK, tasks)
+	if useCursor {
+		c.JSON(http.StatusOK, cursorPage(body, next))
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// taskListOrder resolves ?sort= against the user's saved default, responding
+// with 400 for an unknown sort. It also returns the preferences the list
+// filters need.
+func taskListOrder(c *gin.Context, userID uint) (string, User, bool) {
+	// Saved preferences are only needed for the default sort and date filters
+	sortName := c.Query("sort")
+	var prefs User
+	if sortName == "" || strings.TrimSpace(c.Query("filter")) != "" {
+		db.WithContext(c.Request.Context()).Select("default_task_sort", "timezone").First(&prefs, userID)
+	}
+
+	// Explicit sort wins over the user's saved default
+	if sortName == "" {
+		sortName = prefs.DefaultTaskSort
+	}
+	if sortName == "" {
+		sortName = defaultTaskSort
+	}
+	order, ok := taskSortOrders[sortName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort"})
+		return "", prefs, false
+	}
+
+	return order, prefs, true
+}
+
+// taskListQuery scopes a query to the user's visible tasks and applies
+// ?filter=, responding with 400 if the filter is invalid. Shared by the list
+// and count endpoints so they always agree.
+func taskListQuery(c *gin.Context, userID uint, prefs User) (*gorm.DB, bool) {
+	query := db.WithContext(c.Request.Context()).Model(&Task{}).Where("user_id = ? AND archived_at IS NULL", userID)
+
+	if filter := strings.TrimSpace(c.Query("filter")); filter != "" {
+		where, args, err := parseTaskFilter(filter, userLocation(prefs))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		query = query.Where(where, args...)
+	}
+
+	// scheduled=true keeps tasks that can be worked on now, false those starting later
+	if scheduledStr, ok := c.GetQuery("scheduled"); ok {
+		scheduled, err := strconv.ParseBool(scheduledStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scheduled filter"})
+			return nil, false
+		}
+		if scheduled {
+			query = query.Where("start_date IS NULL OR start_date <= ?", time.Now())
+		} else {
+			query = query.Where("start_date > ?", time.Now())
+		}
+	}
+
+	return query, true
+}
+
+// countTasks returns how many tasks match the list filters without fetching them
+func countTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	// The timezone is only needed to interpret date filters
+	var prefs User
+	if strings.TrimSpace(c.Query("filter")) != "" {
+		db.WithContext(c.Request.Context()).Select("timezone").First(&prefs, userID)
+	}
+
+	query, ok := taskListQuery(c, userID, prefs)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		respondDBError(c, err, "Failed to count tasks")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
 }
 
 func createTask(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
 	var req TaskRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindJSON(c, &req) {
 		return
 	}
+	if !normalizeTaskRequest(c, &req) {
+		return
+	}
+
+	// A known client ID updates the existing task so offline clients can replay creates
+	if req.ClientID != "" {
+		var existing Task
+		err := db.WithContext(c.Request.Context()).Where("user_id = ? AND client_id = ?", userID, req.ClientID).First(&existing).Error
+		if err == nil {
+			if !checkCompletionAllowed(c, existing, req) {
+				return
+			}
+			applyTaskRequest(&existing, req)
+			if err := db.WithContext(c.Request.Context()).Save(&existing).Error; err != nil {
+				respondDBError(c, err, "Failed to update task")
+				return
+			}
+			events.publish(TaskUpdated, existing)
+			c.JSON(http.StatusOK, existing)
+			return
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			respondDBError(c, err, "Failed to create task")
+			return
+		}
+	}
+
+	// Enforce the per-user task cap
+	if limit := currentFeatures().MaxTasksPerUser; limit > 0 {
+		var count int64
+		if err := db.WithContext(c.Request.Context()).Model(&Task{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			respondDBError(c, err, "Failed to create task")
+			return
+		}
+		if count >= int64(limit) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("Task limit reached, you can have at most %d tasks", limit),
+				"count": count,
+				"limit": limit,
+			})
+			return
+		}
+	}
+
+	// Optionally reject duplicates of the user's open tasks
+	if currentFeatures().UniqueTaskTitles || c.Query("unique") == "true" {
+		var count int64
+		if err := db.WithContext(c.Request.Context()).Model(&Task{}).
+			Where("user_id = ? AND completed = ? AND LOWER(title) = LOWER(?)", userID, false, req.Title).
+			Count(&count).Error; err != nil {
+			respondDBError(c, err, "Failed to create task")
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A task with this title already exists"})
+			return
+		}
+	}
+
+	priority := req.Priority
+	if priority == "" {
+		priority = "medium"
+	}
+
+	// Start from the user's template unless a description was sent, even an empty one
+	description := req.Description
+	if !req.descriptionSet {
+		var user User
+		if err := db.WithContext(c.Request.Context()).Select("id", "task_template").First(&user, userID).Error; err != nil {
+			respondDBError(c, err, "Failed to create task")
+			return
+		}
+		description = user.TaskTemplate
+	}
 
 	task := Task{
 		Title:       req.Title,
-		Description: req.Description,
+		Description: description,
+		Priority:    priority,
+		StartDate:   req.StartDate,
 		UserID:      userID,
-		Completed:   false,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	if req.Completed != nil {
+		setTaskCompleted(&task, *req.Completed)
+	}
+	if req.ClientID != "" {
+		task.ClientID = &req.ClientID
+	}
+	if req.Color != nil {
+		task.Color = *req.Color
+	}
+	if req.EstimateMinutes != nil {
+		task.EstimateMinutes = *req.EstimateMinutes
+	}
 
-	if err := db.Create(&task).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task"})
+	if err := db.WithContext(c.Request.Context()).Create(&task).Error; err != nil {
+		respondDBError(c, err, "Failed to create task")
 		return
 	}
 
+	events.publish(TaskCreated, task)
+
 	c.JSON(http.StatusCreated, task)
 }
 
 func getTask(c *gin.Context) {
 	userID := c.GetUint("user_id")
-	taskIDStr := c.Param("id")
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
+		return
+	}
+
+	fields, err := parseTaskFields(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	var taskID uint
-	if _, err := fmt.Sscanf(taskIDStr, "%d", &taskID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+	render := c.Query("render")
+	if render != "" && render != "html" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid render, must be html"})
 		return
 	}
 
+	// A projection only loads its columns, plus those the ownership check,
+	// the ETag and the public id need
+	var columns []string
+	if fields != nil {
+		columns = append([]string{"uuid", "user_id", "updated_at"}, fields...)
+	}
+	task, ok := findUserTask(c, taskID, userID, columns...)
+	if !ok {
+		return
+	}
+
+	// Conditional request support for polling clients. Each representation
+	// gets its own ETag so a cached projection never validates another.
+	variant := ""
+	if fields != nil {
+		variant = "fields=" + strings.Join(fields, ",")
+	} else if render != "" {
+		variant = "render=" + render
+	}
+	etag := taskETag(task, variant)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", task.UpdatedAt.UTC().Format(http.TimeFormat))
+	if notModified(c, etag, task.UpdatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if fields != nil {
+		c.JSON(http.StatusOK, selectTaskFields(task, fields))
+		return
+	}
+
+	tasks := []Task{task}
+	if err := loadTaskDependencies(c.Request.Context(), tasks); err != nil {
+		respondDBError(c, err, "Failed to fetch task")
+		return
+	}
+
+	if render == "html" {
+		html, err := renderMarkdown(tasks[0].Description)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render description"})
+			return
+		}
+		c.JSON(http.StatusOK, RenderedTask{Task: tasks[0], DescriptionHTML: html})
+		return
+	}
+
+	c.JSON(http.StatusOK, tasks[0])
+}
+
+// findUserTask loads a task owned by the user, responding with an error if it
+// cannot. By default a task owned by someone else is reported as 404 so that
+// task IDs cannot be probed for existence. Setting TASK_OWNERSHIP_FORBIDDEN
+// returns 403 instead, which is clearer for internal tooling but reveals that
+// the ID exists; only enable it where that leak is acceptable. Columns limits
+// what is loaded; it must include user_id.
+func findUserTask(c *gin.Context, taskID, userID uint, columns ...string) (Task, bool) {
 	var task Task
-	if err := db.Where("id = ? AND user_id = ?", taskID, userID).First(&task).Error; err != nil {
+	query := db.WithContext(c.Request.Context())
+	if len(columns) > 0 {
+		selected := []string{"id"}
+		seen := map[string]bool{"id": true}
+		for _, column := range columns {
+			if !seen[column] {
+				seen[column] = true
+				selected = append(selected, column)
+			}
+		}
+		query = query.Select(selected)
+	}
+	if !currentFeatures().TaskOwnershipForbidden {
+		if err := query.Where("id = ? AND user_id = ?", taskID, userID).First(&task).Error; err != nil {
+			respondTaskLookupError(c, err)
+			return task, false
+		}
+		return task, true
+	}
+
+	if err := query.First(&task, taskID).Error; err != nil {
+		respondTaskLookupError(c, err)
+		return task, false
+	}
+	if task.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this task"})
+		return Task{}, false
+	}
+	return task, true
+}
+
+// respondTaskLookupError reports a missing task as 404 and anything else as a database failure
+func respondTaskLookupError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
 		return
 	}
+	respondDBError(c, err, "Failed to fetch task")
+}
 
-	c.JSON(http.StatusOK, task)
+// taskETag derives a weak ETag from the task's last modification and, for
+// anything but the full task, a hash of the representation variant
+func taskETag(task Task, variant string) string {
+	if variant == "" {
+		return fmt.Sprintf(`W/"%d-%d"`, task.ID, task.UpdatedAt.UnixNano())
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(variant))
+	return fmt.Sprintf(`W/"%d-%d-%x"`, task.ID, task.UpdatedAt.UnixNano(), hash.Sum32())
+}
+
+// notModified checks If-None-Match, then If-Modified-Since, against the current version
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil {
+			return !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+
+	return false
 }
 
 func updateTask(c *gin.Context) {
 	userID := c.GetUint("user_id")
-	taskIDStr := c.Param("id")
-
-	var taskID uint
-	if _, err := fmt.Sscanf(taskIDStr, "%d", &taskID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
 		return
 	}
 
 	var req TaskRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !normalizeTaskRequest(c, &req) {
 		return
 	}
 
-	var task Task
-	if err := db.Where("id = ? AND user_id = ?", taskID, userID).First(&task).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
 		return
 	}
+	if !checkCompletionAllowed(c, task, req) {
+		return
+	}
+
+	applyTaskRequest(&task, req)
+
+	if err := db.WithContext(c.Request.Context()).Save(&task).Error; err != nil {
+		respondDBError(c, err, "Failed to update task")
+		return
+	}
+	tasks := []Task{task}
+	if err := loadTaskDependencies(c.Request.Context(), tasks); err != nil {
+		respondDBError(c, err, "Failed to update task")
+		return
+	}
+	task = tasks[0]
+
+	events.publish(TaskUpdated, task)
+
+	c.JSON(http.StatusOK, task)
+}
 
-	// Update task
+// applyTaskRequest copies the editable fields of a request onto a task
+func applyTaskRequest(task *Task, req TaskRequest) {
 	task.Title = req.Title
 	task.Description = req.Description
+	if req.Priority != "" {
+		task.Priority = req.Priority
+	}
+	if req.startDateSet {
+		task.StartDate = req.StartDate
+	}
+	if req.Completed != nil {
+		setTaskCompleted(task, *req.Completed)
+	}
+	if req.Color != nil {
+		task.Color = *req.Color
+	}
+	if req.EstimateMinutes != nil {
+		task.EstimateMinutes = *req.EstimateMinutes
+	}
 	task.UpdatedAt = time.Now()
+}
 
-	if err := db.Save(&task).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update task"})
+// setTaskCompleted marks a task done or not, stamping CompletedAt only when
+// the state actually changes so repeated updates keep the original time
+func setTaskCompleted(task *Task, completed bool) {
+	if task.Completed == completed {
 		return
 	}
-
-	c.JSON(http.StatusOK, task)
+	task.Completed = completed
+	if completed {
+		now := time.Now()
+		task.CompletedAt = &now
+	} else {
+		task.CompletedAt = nil
+	}
 }
 
 func deleteTask(c *gin.Context) {
 	userID := c.GetUint("user_id")
-	taskIDStr := c.Param("id")
-
-	var taskID uint
-	if _, err := fmt.Sscanf(taskIDStr, "%d", &taskID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
 		return
 	}
 
 	// Check if task exists and belongs to user
-	var task Task
-	if err := db.Where("id = ? AND user_id = ?", taskID, userID).First(&task).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
 		return
 	}
 
 	// Delete task
-	if err := db.Delete(&task).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete task"})
+	result := db.WithContext(c.Request.Context()).Where("user_id = ?", userID).Delete(&task)
+	if result.Error != nil {
+		respondDBError(c, result.Error, "Failed to delete task")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Task deleted successfully"})
+	if result.RowsAffected > 0 {
+		if err := deleteTaskDependencies(c.Request.Context(), []uint{task.ID}); err != nil {
+			slog.Error("Failed to delete task dependencies", "task_id", task.ID, "error", err)
+		}
+		recordTombstones(c.Request.Context(), userID, []Task{task})
+		events.publish(TaskDeleted, task)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Task deleted successfully",
+		"affected": result.RowsAffected,
+	})
 }
 
+func clearCompletedTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	mode := c.DefaultQuery("mode", "delete")
+
+	if mode != "delete" && mode != "archive" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode, must be delete or archive"})
+		return
+	}
+
+	// Selection shared by the real run and the dry run so previews are accurate
+	selection := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("user_id = ? AND completed = ?", userID, true)
+		if mode == "archive" {
+			tx = tx.Where("archived_at IS NULL")
+		}
+		return tx
+	}
+
+	if c.Query("dry_run") == "true" {
+		var matched []Task
+		if err := db.WithContext(c.Request.Context()).Select("id", "uuid").Scopes(selection).Order("id ASC").Find(&matched).Error; err != nil {
+			respondDBError(c, err, "Failed to clear completed tasks")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run": true,
+			"mode":    mode,
+			"ids":     taskPublicIDs(matched),
+			"count":   len(matched),
+		})
+		return
+	}
+
+	var tasks []Task
+	query := db.WithContext(c.Request.Context()).Clauses(clause.Returning{}).Scopes(selection)
+
+	var result *gorm.DB
+	if mode == "delete" {
+		result = query.Delete(&tasks)
+	} else {
+		result = query.Model(&tasks).Updates(map[string]interface{}{
+			"archived_at": time.Now(),
+			"updated_at":  time.Now(),
+		})
+	}
+
+	if result.Error != nil {
+		respondDBError(c, result.Error, "Failed to clear completed tasks")
+		return
+	}
+
+	eventType := TaskDeleted
+	if mode == "archive" {
+		eventType = TaskUpdated
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+		events.publish(eventType, task)
+	}
+	if mode == "delete" {
+		if err := deleteTaskDependencies(c.Request.Context(), ids); err != nil {
+			slog.Error("Failed to delete task dependencies", "user_id", userID, "error", err)
+		}
+		recordTombstones(c.Request.Context(), userID, tasks)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Completed tasks cleared",
+		"mode":     mode,
+		"ids":      taskPublicIDs(tasks),
+		"count":    len(tasks),
+		"affected": result.RowsAffected,
+	})
+}
+
+// userLocation returns the user's preferred timezone, falling back to UTC
+func userLocation(user User) *time.Location {
+	if user.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// profileResponse is the profile payload shared by the profile endpoints
+func profileResponse(user User) gin.H {
+	return gin.H{
+		"id":                 user.ID,
+		"username":           user.Username,
+		"email":              user.Email,
+		"default_task_sort":  user.DefaultTaskSort,
+		"timezone":           user.Timezone,
+		"task_template":      user.TaskTemplate,
+		"notification_prefs": user.NotificationPrefs,
+		"pending_email":      user.PendingEmail,
+		"last_login_at":      user.LastLoginAt,
+		"last_login_ip":      user.LastLoginIP,
+		"created_at":         user.CreatedAt,
+	}
+}
+
+// profileTaskLimit caps the recent tasks returned with ?include=tasks
+const profileTaskLimit = 10
+
 func getProfile(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
+	includeTasks := false
+	if include := c.Query("include"); include != "" {
+		for _, name := range strings.Split(include, ",") {
+			switch strings.TrimSpace(name) {
+			case "tasks":
+				includeTasks = true
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid include %q, must be tasks", name)})
+				return
+			}
+		}
+	}
+
+	query := db.WithContext(c.Request.Context())
+	if includeTasks {
+		// Most recently touched first, leaving out archived tasks like the task list
+		query = query.Preload("Tasks", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("archived_at IS NULL").Order("updated_at DESC").Limit(profileTaskLimit)
+		})
+	}
+
+	var user User
+	if err := query.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	response := profileResponse(user)
+	if includeTasks {
+		tasks := user.Tasks
+		if tasks == nil {
+			tasks = []Task{}
+		}
+		response["tasks"] = tasks
+	} else {
+		setPreloadHints(c, taskListPreload)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func updateProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req ProfileRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
 	var user User
-	if err := db.First(&user, userID).Error; err != nil {
+	if err := db.WithContext(c.Request.Context()).First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":         user.ID,
-		"username":   user.Username,
-		"email":      user.Email,
-		"created_at": user.CreatedAt,
-	})
+	if req.DefaultTaskSort != nil {
+		if _, ok := taskSortOrders[*req.DefaultTaskSort]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid default_task_sort"})
+			return
+		}
+		user.DefaultTaskSort = *req.DefaultTaskSort
+	}
+
+	if req.Timezone != nil {
+		// LoadLocation also accepts "" and "Local", which aren't IANA zones
+		_, err := time.LoadLocation(*req.Timezone)
+		if err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+			return
+		}
+		user.Timezone = *req.Timezone
+	}
+
+	if req.TaskTemplate != nil {
+		template, err := normalizeTaskTemplate(*req.TaskTemplate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.TaskTemplate = template
+	}
+
+	var confirmationToken string
+	if req.Email != nil {
+		email := normalizeEmail(*req.Email)
+		if email == user.Email {
+			// Setting the current address cancels a pending change
+			clearEmailChange(&user)
+		} else {
+			var existingUser User
+			if err := db.WithContext(c.Request.Context()).Where("LOWER(email) = ? AND id <> ?", email, user.ID).First(&existingUser).Error; err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already exists", "fields": gin.H{"email": "Email already exists"}})
+				return
+			}
+
+			if currentFeatures().EmailChangeConfirmation {
+				token, err := startEmailChange(&user, email)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start email change"})
+					return
+				}
+				confirmationToken = token
+			} else {
+				user.Email = email
+				clearEmailChange(&user)
+			}
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+
+	if err := db.WithContext(c.Request.Context()).Save(&user).Error; err != nil {
+		respondDBError(c, err, "Failed to update profile")
+		return
+	}
+
+	if confirmationToken != "" {
+		sendEmailChangeConfirmation(user, confirmationToken)
+	}
+
+	c.JSON(http.StatusOK, profileResponse(user))
 }
@@ -1,18 +1,44 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // TestMain sets up the test environment
@@ -25,6 +51,9 @@ func TestMain(m *testing.M) {
 		panic("Failed to setup test database: " + err.Error())
 	}
 
+	// Start task event delivery
+	go events.run()
+
 	// Run tests
 	code := m.Run()
 
@@ -46,7 +75,7 @@ func setupTestDB() error {
 	}
 
 	// Auto migrate schema
-	return db.AutoMigrate(&User{}, &Task{})
+	return db.AutoMigrate(&User{}, &Task{}, &Invite{}, &TaskTombstone{}, &TaskDependency{})
 }
 
 // cleanupTestDB cleans up the test database
@@ -64,20 +93,68 @@ func setupTestRouter() *gin.Engine {
 
 	// API routes
 	api := r.Group("/api")
+	api.Use(csrfMiddleware())
+	api.Use(maintenanceMiddleware())
 	{
 		api.POST("/register", register)
 		api.POST("/login", login)
+		api.POST("/logout", logout)
+		api.GET("/csrf-token", getCSRFToken)
+		api.GET("/ping", ping)
+		api.GET("/confirm-email-change", confirmEmailChange)
+		api.POST("/auth/validate", validateToken)
 
 		protected := api.Group("/")
 		protected.Use(authMiddleware())
 		{
 			protected.GET("/tasks", getTasks)
+			protected.GET("/tasks/count", countTasks)
+			protected.GET("/tasks/grouped", getGroupedTasks)
+			protected.GET("/tasks/recent-completed", getRecentlyCompleted)
 			protected.POST("/tasks", createTask)
+			protected.GET("/tasks/stream", streamTasks)
+			protected.DELETE("/tasks/completed", userRateLimit(), clearCompletedTasks)
+			protected.POST("/tasks/bulk-priority", userRateLimit(), bulkUpdatePriority)
 			protected.GET("/tasks/:id", getTask)
+			protected.GET("/tasks/:id/export", userRateLimit(), exportTask)
+			protected.POST("/tasks/:id/time", logTaskTime)
+			protected.POST("/tasks/:id/dependencies", addTaskDependency)
+			protected.DELETE("/tasks/:id/dependencies/:depends_on_id", removeTaskDependency)
 			protected.PUT("/tasks/:id", updateTask)
+			protected.PATCH("/tasks/:id", patchTask)
 			protected.DELETE("/tasks/:id", deleteTask)
+			protected.POST("/batch", userRateLimit(), batch(r))
+			protected.GET("/search", userRateLimit(), search)
+			protected.GET("/stats/heatmap", getCompletionHeatmap)
+			protected.GET("/stats/time", getTimeStats)
+			protected.GET("/auth/whoami", whoami)
 			protected.GET("/profile", getProfile)
+			protected.PUT("/profile", updateProfile)
+			protected.GET("/profile/notifications", getNotificationPrefs)
+			protected.PUT("/profile/notifications", updateNotificationPrefs)
+			protected.GET("/profile/export", userRateLimit(), exportUserData)
+		}
+
+		admin := api.Group("/admin")
+		admin.Use(authMiddleware(), adminMiddleware())
+		{
+			admin.GET("/users", listUsers)
+			admin.GET("/digest", getDigest)
+			admin.GET("/db-stats", getDBStats)
+			admin.GET("/reminders", getDueReminders)
+			admin.POST("/reminders/sent", markRemindersSent)
+			admin.POST("/impersonate/:user_id", impersonateUser)
+			admin.POST("/users/:id/deactivate", deactivateUser)
+			admin.POST("/users/:id/reactivate", reactivateUser)
+			admin.POST("/users/:id/transfer-tasks", transferTasks)
+			admin.POST("/invites", createInvite)
+			admin.GET("/maintenance", getMaintenance)
+			admin.GET("/features", getFeatures)
+			admin.PUT("/maintenance", updateMaintenance)
 		}
+
+		// WebSocket authenticates with a token query param
+		api.GET("/ws", taskSocket)
 	}
 
 	return r
@@ -98,6 +175,70 @@ func TestPasswordHashing(t *testing.T) {
 	assert.False(t, checkPassword("wrongpassword", hash))
 }
 
+// TestPasswordHashAlgorithms tests round-tripping and cross-verifying each algorithm
+func TestPasswordHashAlgorithms(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	password := "testpassword123"
+
+	hashes := map[string]string{}
+	for _, algo := range []string{"bcrypt", "argon2id"} {
+		setFeatureEnv(t, "HASH_ALGO", algo)
+
+		hash, err := hashPassword(password)
+		assert.NoError(t, err)
+		assert.True(t, checkPassword(password, hash), algo)
+		assert.False(t, checkPassword("wrongpassword", hash), algo)
+		assert.False(t, needsRehash(hash), algo)
+		hashes[algo] = hash
+	}
+
+	assert.True(t, strings.HasPrefix(hashes["argon2id"], "$argon2id$v=19$"))
+
+	// Hashes from either algorithm verify whatever HASH_ALGO is set to
+	setFeatureEnv(t, "HASH_ALGO", "bcrypt")
+	assert.True(t, checkPassword(password, hashes["argon2id"]))
+	assert.True(t, needsRehash(hashes["argon2id"]))
+
+	setFeatureEnv(t, "HASH_ALGO", "argon2id")
+	assert.True(t, checkPassword(password, hashes["bcrypt"]))
+	assert.True(t, needsRehash(hashes["bcrypt"]))
+
+	// Unknown hash formats never verify
+	assert.False(t, checkPassword(password, "plaintext"))
+}
+
+// TestPasswordRehash tests that login upgrades hashes below the configured cost
+func TestPasswordRehash(t *testing.T) {
+	router := setupTestRouter()
+	t.Setenv("BCRYPT_COST", "6")
+
+	oldHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	assert.True(t, needsRehash(string(oldHash)))
+
+	user := User{Username: "rehashtestuser", Email: "rehashtest@example.com", Password: string(oldHash), Active: true}
+	db.Create(&user)
+
+	loginData := map[string]interface{}{
+		"username": "rehashtestuser",
+		"password": "password123",
+	}
+
+	jsonData, _ := json.Marshal(loginData)
+	req, _ := http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	db.First(&user, user.ID)
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, cost)
+	assert.False(t, needsRehash(user.Password))
+}
+
 // TestJWTTokenGeneration tests JWT token generation
 func TestJWTTokenGeneration(t *testing.T) {
 	userID := uint(1)
@@ -115,6 +256,131 @@ func TestJWTSecretFunction(t *testing.T) {
 	assert.Len(t, secret, 36) // Default secret length
 }
 
+// testRSAKeyPEM generates an RSA key and returns its private and public PEM encodings
+func testRSAKeyPEM(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	return string(privatePEM), string(publicPEM)
+}
+
+// TestJWTAlgorithms tests RS256 signing, key rotation and alg-confusion rejection
+func TestJWTAlgorithms(t *testing.T) {
+	claims := &Claims{
+		UserID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	// Test HS256 rotation through the previous secret
+	t.Setenv("JWT_ALGORITHM", "HS256")
+	t.Setenv("JWT_SECRET", "old-secret")
+	oldToken, err := generateToken(1)
+	assert.NoError(t, err)
+
+	t.Setenv("JWT_SECRET", "new-secret")
+	_, err = parseToken(oldToken)
+	assert.Error(t, err)
+
+	t.Setenv("JWT_PREVIOUS_SECRET", "old-secret")
+	parsed, err := parseToken(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), parsed.UserID)
+
+	// Test unsigned tokens are rejected
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = parseToken(unsigned)
+	assert.Error(t, err)
+
+	// Test RS256 round trip
+	privatePEM, publicPEM := testRSAKeyPEM(t)
+	t.Setenv("JWT_ALGORITHM", "RS256")
+	t.Setenv("JWT_PRIVATE_KEY", privatePEM)
+
+	rsToken, err := generateToken(2)
+	assert.NoError(t, err)
+	parsed, err = parseToken(rsToken)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), parsed.UserID)
+
+	// Test HS256 tokens are rejected under RS256, even when keyed with the public key
+	confused, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(publicPEM))
+	_, err = parseToken(confused)
+	assert.Error(t, err)
+	_, err = parseToken(oldToken)
+	assert.Error(t, err)
+
+	// Test RS256 rotation through the previous public key
+	newPrivatePEM, _ := testRSAKeyPEM(t)
+	t.Setenv("JWT_PRIVATE_KEY", newPrivatePEM)
+	_, err = parseToken(rsToken)
+	assert.Error(t, err)
+
+	t.Setenv("JWT_PREVIOUS_PUBLIC_KEY", publicPEM)
+	parsed, err = parseToken(rsToken)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), parsed.UserID)
+
+	// Test bad configuration is reported
+	t.Setenv("JWT_ALGORITHM", "ES256")
+	_, err = getJWTKeys()
+	assert.Error(t, err)
+}
+
+// TestParseLogLevel tests LOG_LEVEL parsing
+func TestParseLogLevel(t *testing.T) {
+	level, err := parseLogLevel("")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelInfo, level)
+
+	level, err = parseLogLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+
+	_, err = parseLogLevel("verbose")
+	assert.Error(t, err)
+}
+
+// TestGormLogger tests that GORM output keeps its own severity
+func TestGormLogger(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(previous)
+
+	gl := gormLogger{level: logger.Warn, slowThreshold: 100 * time.Millisecond}
+	query := func() (string, int64) { return "SELECT 1", 1 }
+
+	gl.Trace(context.Background(), time.Now(), query, errors.New("connection reset"))
+	assert.Contains(t, buf.String(), "level=ERROR")
+	assert.Contains(t, buf.String(), "Query failed")
+
+	buf.Reset()
+	gl.Trace(context.Background(), time.Now().Add(-time.Second), query, nil)
+	assert.Contains(t, buf.String(), "level=WARN")
+	assert.Contains(t, buf.String(), "Slow query")
+
+	// Fast queries and missing records are quiet below GORM's info level
+	buf.Reset()
+	gl.Trace(context.Background(), time.Now(), query, nil)
+	gl.Trace(context.Background(), time.Now(), query, gorm.ErrRecordNotFound)
+	assert.Empty(t, buf.String())
+
+	// The default info level keeps statements out of the log
+	previousLevel := logLevel
+	defer func() { logLevel = previousLevel }()
+	logLevel = slog.LevelInfo
+	assert.Equal(t, logger.Warn, newGormLogger().(gormLogger).level)
+	logLevel = slog.LevelDebug
+	assert.Equal(t, logger.Info, newGormLogger().(gormLogger).level)
+}
+
 // TestValidationFunctions tests input validation
 func TestValidationFunctions(t *testing.T) {
 	// Test valid email
@@ -133,6 +399,24 @@ func TestValidationFunctions(t *testing.T) {
 	assert.False(t, isValidPassword(weakPassword))
 }
 
+// TestPing tests the unauthenticated ping probe
+func TestPing(t *testing.T) {
+	router := setupTestRouter()
+
+	req, _ := http.NewRequest("GET", "/api/ping", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, true, response["pong"])
+	assert.NotEmpty(t, response["timestamp"])
+}
+
 // TestUserRegistration tests user registration endpoint
 func TestUserRegistration(t *testing.T) {
 	router := setupTestRouter()
@@ -158,6 +442,11 @@ func TestUserRegistration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "User created successfully", response["message"])
 
+	user := response["user"].(map[string]interface{})
+	assert.NotEmpty(t, user["created_at"])
+	assert.NotEmpty(t, user["updated_at"])
+	assert.NotContains(t, user, "password")
+
 	// Test duplicate username
 	req, _ = http.NewRequest("POST", "/api/register", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -166,6 +455,82 @@ func TestUserRegistration(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var conflict struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	assert.Equal(t, "Username already exists", conflict.Error)
+	assert.Len(t, conflict.Fields, 2)
+
+	// Test duplicate email only
+	jsonData, _ = json.Marshal(map[string]interface{}{
+		"username": "testuser2",
+		"email":    "TEST@example.com",
+		"password": "password123",
+	})
+	req, _ = http.NewRequest("POST", "/api/register", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	conflict.Fields = nil
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	assert.Equal(t, "Email already exists", conflict.Error)
+	assert.Equal(t, map[string]string{"email": "Email already exists"}, conflict.Fields)
+}
+
+// TestRequestBodyErrors tests the distinct errors for empty, malformed and invalid bodies
+func TestRequestBodyErrors(t *testing.T) {
+	router := setupTestRouter()
+
+	cases := []struct {
+		body     string
+		expected string
+	}{
+		{"", "Request body is required"},
+		{`{"username": "abc",`, "Malformed JSON in request body"},
+		{`{"username": 123}`, "Malformed JSON in request body"},
+		{`{"username": "ab", "email": "bad", "password": "1"}`, "Invalid request data"},
+	}
+
+	for _, tc := range cases {
+		req, _ := http.NewRequest("POST", "/api/register", bytes.NewBufferString(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, tc.expected, response["error"], tc.body)
+	}
+
+	// Test the declared content type is checked before the body
+	contentTypes := map[string]int{
+		"text/xml":                          http.StatusUnsupportedMediaType,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+		"application/json; charset=utf-8":   http.StatusBadRequest,
+		"application/vnd.api+json":          http.StatusBadRequest,
+		"":                                  http.StatusBadRequest,
+	}
+	for contentType, expected := range contentTypes {
+		req, _ := http.NewRequest("POST", "/api/register", bytes.NewBufferString(`{"username": "ab"}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, expected, w.Code, contentType)
+	}
 }
 
 // TestUserLogin tests user login endpoint
@@ -221,6 +586,21 @@ func TestUserLogin(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Test login with email as identifier
+	emailLoginData := map[string]interface{}{
+		"identifier": " LoginTest@Example.com",
+		"password":   "password123",
+	}
+
+	jsonData, _ = json.Marshal(emailLoginData)
+	req, _ = http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
 }
 
 // TestTaskCRUD tests task CRUD operations
@@ -333,79 +713,3632 @@ func TestTaskCRUD(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
+
+	var deleteResponse map[string]interface{}
+	err = json.Unmarshal(w.Body.Bytes(), &deleteResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), deleteResponse["affected"])
 }
 
-// TestAuthenticationMiddleware tests the authentication middleware
-func TestAuthenticationMiddleware(t *testing.T) {
+// TestClearCompletedTasks tests deleting and archiving completed tasks
+func TestClearCompletedTasks(t *testing.T) {
 	router := setupTestRouter()
 
-	// Test without token
-	req, _ := http.NewRequest("GET", "/api/tasks", nil)
+	owner := User{Username: "cleartestuser", Email: "cleartest@example.com", Password: "x", Active: true}
+	other := User{Username: "clearotheruser", Email: "clearother@example.com", Password: "x", Active: true}
+	db.Create(&owner)
+	db.Create(&other)
+	token, _ := generateToken(owner.ID)
+
+	db.Create(&[]Task{
+		{Title: "Done 1", UserID: owner.ID, Completed: true},
+		{Title: "Done 2", UserID: owner.ID, Completed: true},
+		{Title: "Open", UserID: owner.ID},
+		{Title: "Other done", UserID: other.ID, Completed: true},
+	})
+
+	// Test invalid mode
+	req, _ := http.NewRequest("DELETE", "/api/tasks/completed?mode=shred", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 
-	// Test with invalid token
+	// Test dry run reports without changing anything
+	req, _ = http.NewRequest("DELETE", "/api/tasks/completed?mode=archive&dry_run=true", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var dryRunResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &dryRunResponse)
+	assert.Equal(t, true, dryRunResponse["dry_run"])
+	assert.Len(t, dryRunResponse["ids"], 2)
+
+	var archived int64
+	db.Model(&Task{}).Where("user_id = ? AND archived_at IS NOT NULL", owner.ID).Count(&archived)
+	assert.Equal(t, int64(0), archived)
+
+	// Test archive
+	req, _ = http.NewRequest("DELETE", "/api/tasks/completed?mode=archive", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), response["count"])
+	assert.Equal(t, float64(2), response["affected"])
+	assert.Equal(t, dryRunResponse["ids"], response["ids"])
+
+	// Archived tasks are hidden from the list
 	req, _ = http.NewRequest("GET", "/api/tasks", nil)
-	req.Header.Set("Authorization", "Bearer invalid-token")
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	var tasksResponse []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &tasksResponse)
+	assert.Len(t, tasksResponse, 1)
+	assert.Equal(t, "Open", tasksResponse[0]["title"])
+
+	// Test delete leaves incomplete and other users' tasks alone
+	req, _ = http.NewRequest("DELETE", "/api/tasks/completed", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var remaining int64
+	db.Model(&Task{}).Where("user_id IN ?", []uint{owner.ID, other.ID}).Count(&remaining)
+	assert.Equal(t, int64(2), remaining)
 }
 
-// TestProfileEndpoint tests the profile endpoint
-func TestProfileEndpoint(t *testing.T) {
+// TestBulkUpdatePriority tests reprioritizing several tasks at once
+func TestBulkUpdatePriority(t *testing.T) {
 	router := setupTestRouter()
 
-	// Register and login to get token
-	registerData := map[string]interface{}{
-		"username": "profiletestuser",
-		"email":    "profiletest@example.com",
-		"password": "password123",
+	owner := User{Username: "bulkprioritytestuser", Email: "bulkprioritytest@example.com", Password: "x", Active: true}
+	other := User{Username: "bulkpriorityotheruser", Email: "bulkpriorityother@example.com", Password: "x", Active: true}
+	db.Create(&owner)
+	db.Create(&other)
+	token, _ := generateToken(owner.ID)
+
+	tasks := []Task{
+		{Title: "First", UserID: owner.ID, Priority: "low"},
+		{Title: "Second", UserID: owner.ID, Priority: "medium"},
+		{Title: "Untouched", UserID: owner.ID, Priority: "low"},
+		{Title: "Not mine", UserID: other.ID, Priority: "low"},
 	}
+	db.Create(&tasks)
 
-	jsonData, _ := json.Marshal(registerData)
-	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
+	bulkPriority := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		jsonData, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", "/api/tasks/bulk-priority", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test invalid priority and empty ID list
+	w := bulkPriority(map[string]interface{}{"ids": []uint{tasks[0].ID}, "priority": "urgent"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = bulkPriority(map[string]interface{}{"ids": []uint{}, "priority": "high"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Test owned tasks are updated and others skipped
+	w = bulkPriority(map[string]interface{}{
+		"ids":      []uint{tasks[0].ID, tasks[1].ID, tasks[3].ID, 999999},
+		"priority": "high",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, float64(2), response["affected"])
+	assert.Equal(t, float64(2), response["skipped"])
+	assert.Equal(t, []interface{}{float64(tasks[0].ID), float64(tasks[1].ID)}, response["ids"])
+
+	var priorities []string
+	db.Model(&Task{}).Where("id IN ?", []uint{tasks[0].ID, tasks[1].ID, tasks[2].ID, tasks[3].ID}).Order("id ASC").Pluck("priority", &priorities)
+	assert.Equal(t, []string{"high", "high", "low", "low"}, priorities)
+
+	// Test the endpoint is rate limited per user
+	setFeatureEnv(t, "USER_RATE_LIMIT", "3")
+	w = bulkPriority(map[string]interface{}{"ids": []uint{tasks[0].ID}, "priority": "low"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+// TestEmptyTaskList tests that a user without tasks gets an empty array
+func TestEmptyTaskList(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "emptytestuser", Email: "emptytest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	req, _ := http.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Login to get token
-	loginData := map[string]interface{}{
-		"username": "profiletestuser",
-		"password": "password123",
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+// TestTaskPaginationHeaders tests pagination headers on the task list
+func TestTaskPaginationHeaders(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "pagetestuser", Email: "pagetest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	for i := 0; i < 5; i++ {
+		db.Create(&Task{Title: fmt.Sprintf("Task %d", i), UserID: user.ID})
 	}
 
-	jsonData, _ = json.Marshal(loginData)
-	req, _ = http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
+	req, _ := http.NewRequest("GET", "/api/tasks?page=2&per_page=2", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	w = httptest.NewRecorder()
+	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	var loginResponse map[string]interface{}
-	json.Unmarshal(w.Body.Bytes(), &loginResponse)
-	token := loginResponse["token"].(string)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, "2", w.Header().Get("X-Page"))
 
-	// Test get profile
-	req, _ = http.NewRequest("GET", "/api/profile", nil)
+	link := w.Header().Get("Link")
+	assert.Contains(t, link, `</api/tasks?page=1&per_page=2>; rel="first"`)
+	assert.Contains(t, link, `</api/tasks?page=1&per_page=2>; rel="prev"`)
+	assert.Contains(t, link, `</api/tasks?page=3&per_page=2>; rel="next"`)
+	assert.Contains(t, link, `</api/tasks?page=3&per_page=2>; rel="last"`)
+
+	var tasksResponse []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &tasksResponse)
+	assert.Len(t, tasksResponse, 2)
+
+	// Test invalid page
+	req, _ = http.NewRequest("GET", "/api/tasks?page=0", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestTaskCursorPagination tests keyset paging stays stable as tasks change
+func TestTaskCursorPagination(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "cursortestuser", Email: "cursortest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	// Two tasks share a timestamp so the id tiebreak is exercised
+	base := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	tasks := make([]Task, 5)
+	for i := range tasks {
+		tasks[i] = Task{Title: fmt.Sprintf("Cursor %d", i), UserID: user.ID, CreatedAt: base.Add(time.Duration(i/2) * time.Hour)}
+		db.Create(&tasks[i])
+	}
+
+	getPage := func(query string) (*httptest.ResponseRecorder, []string) {
+		req, _ := http.NewRequest("GET", "/api/tasks?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var page struct {
+			Tasks []map[string]interface{} `json:"tasks"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &page)
+		titles := []string{}
+		for _, task := range page.Tasks {
+			titles = append(titles, task["title"].(string))
+		}
+		return w, titles
+	}
+	nextCursor := func(w *httptest.ResponseRecorder) interface{} {
+		var page map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return page["next_cursor"]
+	}
+
+	w, titles := getPage("cursor=&per_page=2")
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Cursor 4", "Cursor 3"}, titles)
+	next := w.Header().Get("X-Next-Cursor")
+	assert.NotEmpty(t, next)
+	assert.Equal(t, next, nextCursor(w))
+	assert.Contains(t, w.Header().Get("Link"), `rel="next"`)
 
-	var profileResponse map[string]interface{}
-	err := json.Unmarshal(w.Body.Bytes(), &profileResponse)
-	assert.NoError(t, err)
-	assert.Equal(t, "profiletestuser", profileResponse["username"])
-	assert.Equal(t, "profiletest@example.com", profileResponse["email"])
+	// Test a task added at the top does not shift the next page
+	db.Create(&Task{Title: "Newest", UserID: user.ID, CreatedAt: base.Add(24 * time.Hour)})
+
+	w, titles = getPage("cursor=" + next + "&per_page=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Cursor 2", "Cursor 1"}, titles)
+
+	// Test the body's cursor can be followed without reading headers
+	w, titles = getPage("cursor=" + nextCursor(w).(string) + "&per_page=2")
+	assert.Equal(t, []string{"Cursor 0"}, titles)
+	assert.Empty(t, w.Header().Get("X-Next-Cursor"))
+	assert.Nil(t, nextCursor(w))
+	assert.Contains(t, w.Body.String(), `"next_cursor":null`)
+
+	// Test ascending order and sparse fields
+	w, titles = getPage("cursor=&per_page=3&sort=created_at_asc&fields=title")
+	assert.Equal(t, []string{"Cursor 0", "Cursor 1", "Cursor 2"}, titles)
+	assert.NotContains(t, w.Body.String(), "created_at")
+	_, titles = getPage("cursor=" + w.Header().Get("X-Next-Cursor") + "&per_page=3&sort=created_at_asc")
+	assert.Equal(t, []string{"Cursor 3", "Cursor 4", "Newest"}, titles)
+
+	// Test invalid combinations
+	for _, query := range []string{"cursor=&page=2", "cursor=garbage", "cursor=&sort=title_asc"} {
+		w, _ = getPage(query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// TestUniqueTaskTitles tests optional duplicate title rejection
+func TestUniqueTaskTitles(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "uniquetestuser", Email: "uniquetest@example.com", Password: "x", Active: true}
+	other := User{Username: "uniqueotheruser", Email: "uniqueother@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	db.Create(&other)
+	token, _ := generateToken(user.ID)
+
+	db.Create(&Task{Title: "Buy Milk", UserID: user.ID})
+	db.Create(&Task{Title: "Walk dog", UserID: user.ID, Completed: true})
+	db.Create(&Task{Title: "Call mom", UserID: other.ID})
+
+	createTask := func(title, query string) int {
+		jsonData, _ := json.Marshal(map[string]interface{}{"title": title})
+		req, _ := http.NewRequest("POST", "/api/tasks"+query, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Default stays permissive
+	assert.Equal(t, http.StatusCreated, createTask("buy milk", ""))
+
+	// Case-insensitive duplicate of an open task
+	assert.Equal(t, http.StatusConflict, createTask("BUY MILK", "?unique=true"))
+
+	// Completed tasks and other users' tasks do not count
+	assert.Equal(t, http.StatusCreated, createTask("Walk dog", "?unique=true"))
+	assert.Equal(t, http.StatusCreated, createTask("Call mom", "?unique=true"))
+}
+
+// TestDefaultTaskSort tests the per-user default task ordering
+func TestDefaultTaskSort(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "sorttestuser", Email: "sorttest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	db.Create(&Task{Title: "banana", UserID: user.ID})
+	db.Create(&Task{Title: "Apple", UserID: user.ID})
+	db.Create(&Task{Title: "cherry", UserID: user.ID})
+
+	getTitles := func(query string) []interface{} {
+		req, _ := http.NewRequest("GET", "/api/tasks"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var tasksResponse []map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &tasksResponse)
+
+		var titles []interface{}
+		for _, task := range tasksResponse {
+			titles = append(titles, task["title"])
+		}
+		return titles
+	}
+
+	// Test invalid preference
+	jsonData, _ := json.Marshal(map[string]interface{}{"default_task_sort": "random"})
+	req, _ := http.NewRequest("PUT", "/api/profile", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Test saving a preference
+	jsonData, _ = json.Marshal(map[string]interface{}{"default_task_sort": "title_asc"})
+	req, _ = http.NewRequest("PUT", "/api/profile", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []interface{}{"Apple", "banana", "cherry"}, getTitles(""))
+
+	// Explicit sort overrides the preference
+	assert.Equal(t, []interface{}{"cherry", "banana", "Apple"}, getTitles("?sort=title_desc"))
+
+	// Test invalid sort
+	req, _ = http.NewRequest("GET", "/api/tasks?sort=random", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestConditionalTaskGet tests ETag and Last-Modified handling on single task GET
+func TestConditionalTaskGet(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "etagtestuser", Email: "etagtest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	task := Task{Title: "Polled Task", UserID: user.ID}
+	db.Create(&task)
+	taskURL := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	req, _ := http.NewRequest("GET", taskURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	// Test If-None-Match
+	req, _ = http.NewRequest("GET", taskURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", etag)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// Test another representation does not validate against the full task's ETag
+	for _, query := range []string{"?fields=title", "?render=html"} {
+		req, _ = http.NewRequest("GET", taskURL+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("If-None-Match", etag)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, query)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"), query)
+	}
+
+	// Test If-Modified-Since
+	req, _ = http.NewRequest("GET", taskURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Modified-Since", lastModified)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// Updating the task changes the ETag
+	jsonData, _ := json.Marshal(map[string]interface{}{"title": "Polled Task v2"})
+	req, _ = http.NewRequest("PUT", taskURL, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	req, _ = http.NewRequest("GET", taskURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", etag)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+// TestSearch tests the grouped search endpoint
+func TestSearch(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "searchtestuser", Email: "searchtest@example.com", Password: "x", Active: true}
+	other := User{Username: "searchotheruser", Email: "searchother@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	db.Create(&other)
+	token, _ := generateToken(user.ID)
+
+	db.Create(&Task{Title: "Quarterly report", UserID: user.ID})
+	db.Create(&Task{Title: "Email", Description: "Send the REPORT to finance", UserID: user.ID})
+	db.Create(&Task{Title: "100% done", UserID: user.ID})
+	db.Create(&Task{Title: "Other report", UserID: other.ID})
+
+	searchTasks := func(q string) []interface{} {
+		req, _ := http.NewRequest("GET", "/api/search?q="+url.QueryEscape(q), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response["tasks"].([]interface{})
+	}
+
+	// Case-insensitive match on title and description, scoped to the user
+	assert.Len(t, searchTasks("report"), 2)
+
+	// Wildcards are matched literally
+	assert.Len(t, searchTasks("%"), 1)
+
+	// Test missing query
+	req, _ := http.NewRequest("GET", "/api/search", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestAdminListUsers tests searching and filtering the admin user list
+func TestAdminListUsers(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "listadminuser", Email: "listadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	db.Create(&admin)
+	adminToken, _ := generateToken(admin.ID)
+
+	db.Create(&User{Username: "listuser_one", Email: "one@listusers.test", Password: "x", Active: true})
+	inactive := User{Username: "listuser_two", Email: "two@listusers.test", Password: "x", Active: true}
+	db.Create(&inactive)
+	db.Model(&inactive).Update("active", false)
+
+	req, _ := http.NewRequest("GET", "/api/admin/users?q=listusers.test&active=false", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Total-Count"))
+	assert.NotContains(t, w.Body.String(), "password")
+
+	var usersResponse []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &usersResponse)
+	assert.Len(t, usersResponse, 1)
+	assert.Equal(t, "listuser_two", usersResponse[0]["username"])
+
+	// Test invalid filter
+	req, _ = http.NewRequest("GET", "/api/admin/users?active=maybe", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestPrioritySort tests priority ordering with created_at as the tie breaker
+func TestPrioritySort(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "prioritytestuser", Email: "prioritytest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	base := time.Now().Add(-time.Hour)
+	db.Create(&[]Task{
+		{Title: "low old", Priority: "low", UserID: user.ID, CreatedAt: base},
+		{Title: "high old", Priority: "high", UserID: user.ID, CreatedAt: base.Add(time.Minute)},
+		{Title: "medium", Priority: "medium", UserID: user.ID, CreatedAt: base.Add(2 * time.Minute)},
+		{Title: "high new", Priority: "high", UserID: user.ID, CreatedAt: base.Add(3 * time.Minute)},
+		{Title: "low new", Priority: "low", UserID: user.ID, CreatedAt: base.Add(4 * time.Minute)},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/tasks?sort=priority", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tasksResponse []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &tasksResponse)
+
+	var titles []interface{}
+	for _, task := range tasksResponse {
+		titles = append(titles, task["title"])
+	}
+	assert.Equal(t, []interface{}{"high new", "high old", "medium", "low new", "low old"}, titles)
+
+	// Test invalid priority on create
+	jsonData, _ := json.Marshal(map[string]interface{}{"title": "Urgent", "priority": "urgent"})
+	req, _ = http.NewRequest("POST", "/api/tasks", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestAdminDigest tests the per-user pending task digest
+func TestAdminDigest(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "digestadminuser", Email: "digestadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	busy := User{Username: "digestbusyuser", Email: "digestbusy@example.com", Password: "x", Active: true}
+	db.Create(&admin)
+	db.Create(&busy)
+	adminToken, _ := generateToken(admin.ID)
+
+	db.Create(&[]Task{
+		{Title: "Pending 1", UserID: busy.ID},
+		{Title: "Pending 2", UserID: busy.ID},
+		{Title: "Done", UserID: busy.ID, Completed: true},
+		{Title: "Admin pending", UserID: admin.ID},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/admin/digest?min_pending=2", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Users []UserDigest `json:"users"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	var found *UserDigest
+	for i, digest := range response.Users {
+		assert.GreaterOrEqual(t, digest.Pending, int64(2))
+		assert.NotEqual(t, admin.ID, digest.UserID)
+		if digest.UserID == busy.ID {
+			found = &response.Users[i]
+		}
+	}
+	if assert.NotNil(t, found) {
+		assert.Equal(t, int64(2), found.Pending)
+	}
+}
+
+// TestTaskOwnershipErrors tests 404 versus 403 for other users' tasks
+func TestTaskOwnershipErrors(t *testing.T) {
+	router := setupTestRouter()
+
+	owner := User{Username: "ownertestuser", Email: "ownertest@example.com", Password: "x", Active: true}
+	intruder := User{Username: "intrudertestuser", Email: "intrudertest@example.com", Password: "x", Active: true}
+	db.Create(&owner)
+	db.Create(&intruder)
+	token, _ := generateToken(intruder.ID)
+
+	task := Task{Title: "Private", UserID: owner.ID}
+	db.Create(&task)
+
+	getStatus := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Default hides existence
+	assert.Equal(t, http.StatusNotFound, getStatus(fmt.Sprintf("/api/tasks/%d", task.ID)))
+
+	setFeatureEnv(t, "TASK_OWNERSHIP_FORBIDDEN", "true")
+	assert.Equal(t, http.StatusForbidden, getStatus(fmt.Sprintf("/api/tasks/%d", task.ID)))
+	assert.Equal(t, http.StatusNotFound, getStatus("/api/tasks/999999"))
+}
+
+// TestTaskLengthValidation tests title trimming and length limits
+func TestTaskLengthValidation(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "TASK_DESCRIPTION_MAX_LENGTH", "10")
+
+	user := User{Username: "lengthtestuser", Email: "lengthtest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	createTask := func(data map[string]interface{}) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(data)
+		req, _ := http.NewRequest("POST", "/api/tasks", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := createTask(map[string]interface{}{"title": "  Trimmed  "})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Trimmed", response["title"])
+
+	code, response = createTask(map[string]interface{}{"title": "   "})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response["fields"], "title")
+
+	code, response = createTask(map[string]interface{}{"title": strings.Repeat("a", 256)})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response["fields"], "title")
+
+	code, response = createTask(map[string]interface{}{"title": "Long", "description": "more than ten"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response["fields"], "description")
+	// Test the byte cap applies before the character limit
+	setFeatureEnv(t, "TASK_DESCRIPTION_MAX_BYTES", "8")
+	code, response = createTask(map[string]interface{}{"title": "Bytes", "description": "ééééé"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Contains(t, response["error"], "8 bytes")
+}
+
+// TestTaskInputSanitization tests that control characters are stripped from task text
+func TestTaskInputSanitization(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "sanitizetestuser", Email: "sanitizetest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	sendTask := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(data)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// Test control characters are stripped and whitespace collapsed
+	code, response := sendTask("POST", "/api/tasks", map[string]interface{}{
+		"title":       "  Pay\x1b[31m   the\tbill\r\n\u0085now\x7f ",
+		"description": "Line one\r\nLine\x07 two\n\tindented\x1b",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Pay[31m the bill now", response["title"])
+	assert.Equal(t, "Line one\nLine two\n\tindented", response["description"])
+
+	// Test null bytes in titles are rejected on create and update
+	code, response = sendTask("POST", "/api/tasks", map[string]interface{}{"title": "Evil\x00title"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response["fields"], "title")
+
+	var task Task
+	db.Where("user_id = ?", user.ID).First(&task)
+	taskURL := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	code, response = sendTask("PUT", taskURL, map[string]interface{}{"title": "\x00"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response["fields"], "title")
+
+	// Test a title of only control characters counts as empty
+	code, response = sendTask("PUT", taskURL, map[string]interface{}{"title": "\x01\x02\x1f"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Title is required", response["fields"].(map[string]interface{})["title"])
+
+	// Test updates are sanitized too
+	code, response = sendTask("PUT", taskURL, map[string]interface{}{"title": "Renamed\x08\x08 task", "description": "ok\x00"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Renamed task", response["title"])
+	assert.Equal(t, "ok", response["description"])
+}
+
+// TestAdminImpersonation tests impersonation tokens for support staff
+func TestAdminImpersonation(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "impadminuser", Email: "impadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	target := User{Username: "imptargetuser", Email: "imptarget@example.com", Password: "x", Active: true}
+	db.Create(&admin)
+	db.Create(&target)
+	adminToken, _ := generateToken(admin.ID)
+
+	db.Create(&Task{Title: "Target task", UserID: target.ID})
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/admin/impersonate/%d", target.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	token := response["token"].(string)
+
+	claims, err := parseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, target.ID, claims.UserID)
+	assert.Equal(t, admin.ID, claims.ImpersonatedBy)
+	assert.True(t, claims.ExpiresAt.Time.Before(time.Now().Add(time.Hour)))
+
+	// The token sees the target's tasks and is flagged
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, fmt.Sprintf("%d", admin.ID), w.Header().Get("X-Impersonated-By"))
+
+	var tasksResponse []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &tasksResponse)
+	assert.Len(t, tasksResponse, 1)
+
+	// Admins cannot be impersonated
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/impersonate/%d", admin.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// TestBatch tests running several API calls in one request
+func TestBatch(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "batchtestuser", Email: "batchtest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	batchData := map[string]interface{}{
+		"requests": []map[string]interface{}{
+			{"method": "POST", "path": "/api/tasks", "body": map[string]interface{}{"title": "Batched"}},
+			{"method": "GET", "path": "/api/tasks"},
+			{"method": "GET", "path": "/api/profile"},
+		},
+	}
+
+	jsonData, _ := json.Marshal(batchData)
+	req, _ := http.NewRequest("POST", "/api/batch", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var responses []BatchSubResponse
+	err := json.Unmarshal(w.Body.Bytes(), &responses)
+	assert.NoError(t, err)
+	if assert.Len(t, responses, 3) {
+		assert.Equal(t, http.StatusCreated, responses[0].Status)
+		assert.Equal(t, http.StatusOK, responses[1].Status)
+		assert.Contains(t, string(responses[1].Body), "Batched")
+		assert.Contains(t, string(responses[2].Body), "batchtestuser")
+	}
+
+	// Test PATCH defaults to the merge patch type and a 403 does not stop the batch
+	setFeatureEnv(t, "TASK_OWNERSHIP_FORBIDDEN", "true")
+	other := User{Username: "batchotheruser", Email: "batchother@example.com", Password: "x", Active: true}
+	db.Create(&other)
+	othersTask := Task{Title: "Not yours", UserID: other.ID}
+	db.Create(&othersTask)
+	var batched Task
+	db.Where("user_id = ? AND title = ?", user.ID, "Batched").First(&batched)
+
+	jsonData, _ = json.Marshal(map[string]interface{}{
+		"requests": []map[string]interface{}{
+			{"method": "PATCH", "path": fmt.Sprintf("/api/tasks/%d", batched.ID), "body": map[string]interface{}{"priority": "high"}},
+			{"method": "GET", "path": fmt.Sprintf("/api/tasks/%d", othersTask.ID)},
+			{"method": "GET", "path": "/api/profile"},
+		},
+	})
+	req, _ = http.NewRequest("POST", "/api/batch", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	responses = nil
+	json.Unmarshal(w.Body.Bytes(), &responses)
+	if assert.Len(t, responses, 3) {
+		assert.Equal(t, http.StatusOK, responses[0].Status)
+		assert.Contains(t, string(responses[0].Body), `"priority":"high"`)
+		assert.Equal(t, http.StatusForbidden, responses[1].Status)
+		assert.Equal(t, http.StatusOK, responses[2].Status)
+	}
+
+	// Test nested batches are rejected
+	jsonData, _ = json.Marshal(map[string]interface{}{
+		"requests": []map[string]interface{}{{"method": "POST", "path": "/api/batch"}},
+	})
+	req, _ = http.NewRequest("POST", "/api/batch", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestMaxTasksPerUser tests the per-user task cap
+func TestMaxTasksPerUser(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "MAX_TASKS_PER_USER", "2")
+
+	user := User{Username: "captestuser", Email: "captest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		jsonData, _ := json.Marshal(map[string]interface{}{"title": fmt.Sprintf("Task %d", i)})
+		req, _ := http.NewRequest("POST", "/api/tasks", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	}
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, float64(2), response["count"])
+	assert.Equal(t, float64(2), response["limit"])
+}
+
+// TestExportTask tests exporting a task as markdown and JSON
+func TestExportTask(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "exporttestuser", Email: "exporttest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	task := Task{Title: "Write docs", Description: "Cover the API", Completed: true, Priority: "high", UserID: user.ID}
+	db.Create(&task)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/tasks/%d/export?format=md", task.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/markdown")
+	assert.Contains(t, w.Header().Get("Content-Disposition"), fmt.Sprintf("task-%d.md", task.ID))
+	assert.True(t, strings.HasPrefix(w.Body.String(), "# Write docs\n"))
+	assert.Contains(t, w.Body.String(), "- [x] Completed")
+	assert.Contains(t, w.Body.String(), "Cover the API")
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/tasks/%d/export?format=json", task.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var exported map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &exported)
+	assert.NoError(t, err)
+	assert.Equal(t, "Write docs", exported["title"])
+
+	// Test invalid format
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/tasks/%d/export?format=pdf", task.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestRegistrationClosed tests that signups are refused when registration is closed
+func TestRegistrationClosed(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "REGISTRATION_OPEN", "false")
+
+	body, _ := json.Marshal(RegisterRequest{
+		Username: "closedreguser",
+		Email:    "closedreg@example.com",
+		Password: "password123",
+	})
+
+	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var count int64
+	db.Model(&User{}).Where("username = ?", "closedreguser").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// TestInviteRegistration tests registering with invite tokens in invite-only mode
+func TestInviteRegistration(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "INVITE_ONLY", "true")
+	t.Setenv("BCRYPT_COST", "4")
+
+	admin := User{Username: "inviteadminuser", Email: "inviteadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	db.Create(&admin)
+	adminToken, _ := generateToken(admin.ID)
+
+	createInvite := func(email string) Invite {
+		body, _ := json.Marshal(InviteRequest{Email: email})
+		req, _ := http.NewRequest("POST", "/api/admin/invites", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var invite Invite
+		json.Unmarshal(w.Body.Bytes(), &invite)
+		assert.NotEmpty(t, invite.Token)
+		return invite
+	}
+
+	register := func(username, email, token string) int {
+		body, _ := json.Marshal(RegisterRequest{Username: username, Email: email, Password: "password123", InviteToken: token})
+		req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test registration without a token
+	assert.Equal(t, http.StatusForbidden, register("invitenotoken", "invitenotoken@example.com", ""))
+
+	// Test an open invite can be used once
+	invite := createInvite("")
+	assert.Equal(t, http.StatusCreated, register("inviteduser", "invited@example.com", invite.Token))
+	assert.Equal(t, http.StatusForbidden, register("inviteduser2", "invited2@example.com", invite.Token))
+
+	var used Invite
+	db.First(&used, invite.ID)
+	assert.True(t, used.Used)
+	assert.NotNil(t, used.UsedAt)
+
+	// Test an email-bound invite rejects other addresses
+	bound := createInvite("Bound@Example.com")
+	assert.Equal(t, http.StatusForbidden, register("boundother", "other@example.com", bound.Token))
+	assert.Equal(t, http.StatusCreated, register("bounduser", "bound@example.com", bound.Token))
+
+	// Test the invite is left unused when registration fails
+	conflict := createInvite("")
+	assert.Equal(t, http.StatusConflict, register("inviteduser", "conflict@example.com", conflict.Token))
+	var unused Invite
+	db.First(&unused, conflict.ID)
+	assert.False(t, unused.Used)
+}
+
+// TestTaskFilter tests the filter expression on the task list
+func TestTaskFilter(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "filtertestuser", Email: "filtertest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	old := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tasks := []Task{
+		{Title: "Open high", Priority: "high", UserID: user.ID},
+		{Title: "Open low", Priority: "low", UserID: user.ID},
+		{Title: "Done high", Priority: "high", Completed: true, UserID: user.ID},
+		{Title: "Old task", Priority: "medium", UserID: user.ID, CreatedAt: old, UpdatedAt: old},
+	}
+	db.Create(&tasks)
+
+	fetch := func(filter string) (int, []string) {
+		req, _ := http.NewRequest("GET", "/api/tasks?filter="+url.QueryEscape(filter), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var result []Task
+		json.Unmarshal(w.Body.Bytes(), &result)
+		titles := []string{}
+		for _, task := range result {
+			titles = append(titles, task.Title)
+		}
+		return w.Code, titles
+	}
+
+	code, titles := fetch("completed:false AND priority:high")
+	assert.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []string{"Open high"}, titles)
+
+	code, titles = fetch("priority:low OR (completed:true AND priority:high)")
+	assert.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []string{"Open low", "Done high"}, titles)
+
+	code, titles = fetch(`title:"Old task"`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []string{"Old task"}, titles)
+
+	code, titles = fetch("created_at:<2024-02-01")
+	assert.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []string{"Old task"}, titles)
+
+	code, titles = fetch("created_at:2024-01-15")
+	assert.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []string{"Old task"}, titles)
+
+	// Test invalid expressions are rejected
+	for _, filter := range []string{
+		"owner:1",
+		"completed:>true",
+		"priority:urgent",
+		"created_at:yesterday",
+		"completed:false AND",
+		"(completed:false",
+		"completed",
+	} {
+		code, _ = fetch(filter)
+		assert.Equal(t, http.StatusBadRequest, code, filter)
+	}
+}
+
+// TestTimezonePreference tests saving and validating the profile timezone
+func TestTimezonePreference(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "tztestuser", Email: "tztest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	updateTimezone := func(tz string) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(map[string]interface{}{"timezone": tz})
+		req, _ := http.NewRequest("PUT", "/api/profile", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// Test invalid zones
+	for _, tz := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		code, _ := updateTimezone(tz)
+		assert.Equal(t, http.StatusBadRequest, code, tz)
+	}
+
+	code, response := updateTimezone("America/New_York")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "America/New_York", response["timezone"])
+
+	var updated User
+	db.First(&updated, user.ID)
+	assert.Equal(t, "America/New_York", updated.Timezone)
+	assert.Equal(t, "America/New_York", userLocation(updated).String())
+
+	// Bare filter dates cover the whole day in the user's zone
+	_, args, err := parseTaskFilter("created_at:2024-03-10", userLocation(updated))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 10, 5, 0, 0, 0, time.UTC), args[0].(time.Time).UTC())
+}
+
+// TestEmailChangeConfirmation tests that a new email only applies once confirmed
+func TestEmailChangeConfirmation(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "emailchangeuser", Email: "emailchange@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	db.Create(&User{Username: "emailtakenuser", Email: "taken@example.com", Password: "x", Active: true})
+	token, _ := generateToken(user.ID)
+
+	updateEmail := func(email string) int {
+		jsonData, _ := json.Marshal(map[string]interface{}{"email": email})
+		req, _ := http.NewRequest("PUT", "/api/profile", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	confirm := func(confirmToken string) int {
+		req, _ := http.NewRequest("GET", "/api/confirm-email-change?token="+url.QueryEscape(confirmToken), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test an address already in use
+	assert.Equal(t, http.StatusConflict, updateEmail("Taken@example.com"))
+
+	// Test the change is held until confirmed
+	assert.Equal(t, http.StatusOK, updateEmail("New@Example.com"))
+
+	var pending User
+	db.First(&pending, user.ID)
+	assert.Equal(t, "emailchange@example.com", pending.Email)
+	assert.Equal(t, "new@example.com", pending.PendingEmail)
+	assert.NotEmpty(t, pending.EmailChangeToken)
+
+	assert.Equal(t, http.StatusBadRequest, confirm("not-a-token"))
+	assert.Equal(t, http.StatusOK, confirm(pending.EmailChangeToken))
+
+	var confirmed User
+	db.First(&confirmed, user.ID)
+	assert.Equal(t, "new@example.com", confirmed.Email)
+	assert.Empty(t, confirmed.PendingEmail)
+	assert.Empty(t, confirmed.EmailChangeToken)
+
+	// Test a token can only be used once
+	assert.Equal(t, http.StatusBadRequest, confirm(pending.EmailChangeToken))
+
+	// Test expired tokens are rejected
+	assert.Equal(t, http.StatusOK, updateEmail("later@example.com"))
+	var expiring User
+	db.First(&expiring, user.ID)
+	db.Model(&User{}).Where("id = ?", user.ID).Update("email_change_expires_at", time.Now().Add(-time.Minute))
+	assert.Equal(t, http.StatusBadRequest, confirm(expiring.EmailChangeToken))
+
+	// Test confirmation can be disabled
+	setFeatureEnv(t, "EMAIL_CHANGE_CONFIRMATION", "false")
+	assert.Equal(t, http.StatusOK, updateEmail("direct@example.com"))
+
+	var direct User
+	db.First(&direct, user.ID)
+	assert.Equal(t, "direct@example.com", direct.Email)
+	assert.Empty(t, direct.PendingEmail)
+}
+
+// TestDBStats tests the admin database pool statistics endpoint
+func TestDBStats(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "dbstatsadmin", Email: "dbstatsadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	db.Create(&admin)
+	adminToken, _ := generateToken(admin.ID)
+
+	user := User{Username: "dbstatsuser", Email: "dbstatsuser@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	userToken, _ := generateToken(user.ID)
+
+	// Test non-admins are rejected
+	req, _ := http.NewRequest("GET", "/api/admin/db-stats", nil)
+	req.Header.Set("Authorization", "Bearer "+userToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req, _ = http.NewRequest("GET", "/api/admin/db-stats", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var stats map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &stats)
+	assert.NoError(t, err)
+	assert.Contains(t, stats, "open_connections")
+	assert.Contains(t, stats, "in_use")
+	assert.Contains(t, stats, "idle")
+	assert.Contains(t, stats, "wait_count")
+	assert.Contains(t, stats, "wait_duration_ms")
+}
+
+// TestSparseFieldsets tests limiting task responses to requested fields
+func TestSparseFieldsets(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "fieldstestuser", Email: "fieldstest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	task := Task{Title: "Sparse task", Description: "Not wanted", Completed: true, UserID: user.ID}
+	db.Create(&task)
+
+	req, _ := http.NewRequest("GET", "/api/tasks?fields=title,completed", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tasks []map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &tasks)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, map[string]interface{}{
+		"id":        float64(task.ID),
+		"title":     "Sparse task",
+		"completed": true,
+	}, tasks[0])
+
+	// Test single task fetch
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/tasks/%d?fields=description", task.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var single map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &single)
+	assert.Equal(t, map[string]interface{}{
+		"id":          float64(task.ID),
+		"description": "Not wanted",
+	}, single)
+
+	// Test unknown fields
+	req, _ = http.NewRequest("GET", "/api/tasks?fields=title,password", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestReminders tests that reminded tasks are skipped until the window passes
+func TestReminders(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "REMINDER_WINDOW", "1h")
+
+	admin := User{Username: "reminderadmin", Email: "reminderadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	db.Create(&admin)
+	adminToken, _ := generateToken(admin.ID)
+
+	user := User{Username: "reminderuser", Email: "reminderuser@example.com", Password: "x", Active: true}
+	db.Create(&user)
+
+	stale := time.Now().Add(-2 * time.Hour)
+	open := Task{Title: "Needs reminder", UserID: user.ID}
+	done := Task{Title: "Already done", Completed: true, UserID: user.ID}
+	resend := Task{Title: "Reminded long ago", UserID: user.ID, ReminderSentAt: &stale}
+	db.Create(&open)
+	db.Create(&done)
+	db.Create(&resend)
+
+	dueIDs := func() []uint {
+		req, _ := http.NewRequest("GET", "/api/admin/reminders", nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var tasks []Task
+		json.Unmarshal(w.Body.Bytes(), &tasks)
+		ids := []uint{}
+		for _, task := range tasks {
+			if task.UserID == user.ID {
+				ids = append(ids, task.ID)
+			}
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t, []uint{open.ID, resend.ID}, dueIDs())
+
+	jsonData, _ := json.Marshal(gin.H{"task_ids": []uint{open.ID, resend.ID}})
+	req, _ := http.NewRequest("POST", "/api/admin/reminders/sent", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, dueIDs())
+}
+
+// TestCountTasks tests the count endpoint agrees with the list filters
+func TestCountTasks(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "counttestuser", Email: "counttest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	archivedAt := time.Now()
+	db.Create(&[]Task{
+		{Title: "Open one", UserID: user.ID},
+		{Title: "Open two", Priority: "high", UserID: user.ID},
+		{Title: "Done", Completed: true, UserID: user.ID},
+		{Title: "Archived", Completed: true, ArchivedAt: &archivedAt, UserID: user.ID},
+	})
+
+	count := func(query string) (int, float64) {
+		req, _ := http.NewRequest("GET", "/api/tasks/count"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		n, _ := response["count"].(float64)
+		return w.Code, n
+	}
+
+	code, n := count("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(3), n)
+
+	code, n = count("?filter=" + url.QueryEscape("completed:false"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), n)
+
+	code, n = count("?filter=" + url.QueryEscape("completed:false AND priority:high"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), n)
+
+	code, _ = count("?filter=" + url.QueryEscape("color:red"))
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// TestScheduledTasks tests start dates and the scheduled filter
+func TestScheduledTasks(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "scheduletestuser", Email: "scheduletest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	createTask := func(title string, start time.Time) {
+		jsonData, _ := json.Marshal(TaskRequest{Title: title, StartDate: &start})
+		req, _ := http.NewRequest("POST", "/api/tasks", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var task map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &task)
+		assert.NotNil(t, task["start_date"])
+	}
+
+	createTask("Started", time.Now().Add(-time.Hour))
+	createTask("Later", time.Now().Add(48*time.Hour))
+	db.Create(&Task{Title: "Unscheduled", UserID: user.ID})
+
+	titles := func(query string) []string {
+		req, _ := http.NewRequest("GET", "/api/tasks"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var tasks []Task
+		json.Unmarshal(w.Body.Bytes(), &tasks)
+		result := []string{}
+		for _, task := range tasks {
+			result = append(result, task.Title)
+		}
+		return result
+	}
+
+	assert.ElementsMatch(t, []string{"Started", "Unscheduled"}, titles("?scheduled=true"))
+	assert.ElementsMatch(t, []string{"Later"}, titles("?scheduled=false"))
+	assert.Len(t, titles(""), 3)
+
+	req, _ := http.NewRequest("GET", "/api/tasks?scheduled=soon", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Test an update without start_date keeps it and an explicit null clears it
+	var later Task
+	db.Where("user_id = ? AND title = ?", user.ID, "Later").First(&later)
+	update := func(body string) Task {
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/api/tasks/%d", later.ID), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var updated Task
+		json.Unmarshal(w.Body.Bytes(), &updated)
+		return updated
+	}
+
+	assert.NotNil(t, update(`{"title": "Later, renamed"}`).StartDate)
+	assert.Nil(t, update(`{"title": "Later, renamed", "start_date": null}`).StartDate)
+}
+
+// TestDBUnavailableErrors tests connection failures map to 503 and query errors to 500
+func TestDBUnavailableErrors(t *testing.T) {
+	unavailable := []error{
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		&pgconn.PgError{Code: "08006"},
+		&pgconn.PgError{Code: "57P01"},
+		fmt.Errorf("query failed: %w", driver.ErrBadConn),
+	}
+	for _, err := range unavailable {
+		assert.True(t, isDBUnavailable(err), err.Error())
+	}
+
+	queryErrors := []error{
+		&pgconn.PgError{Code: "23505"},
+		&pgconn.PgError{Code: "42P01"},
+		gorm.ErrRecordNotFound,
+	}
+	for _, err := range queryErrors {
+		assert.False(t, isDBUnavailable(err), err.Error())
+	}
+
+	respond := func(err error) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		respondDBError(c, err, "Failed to fetch tasks")
+		return w
+	}
+
+	w := respond(unavailable[0])
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	w = respond(queryErrors[0])
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Failed to fetch tasks")
+
+	w = respond(&pgconn.PgError{Code: "23514", ConstraintName: "chk_tasks_priority"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid priority")
+}
+
+// TestPriorityCheckConstraint tests the database rejects priorities the API would
+func TestPriorityCheckConstraint(t *testing.T) {
+	user := User{Username: "checkuser", Email: "check@example.com", Password: "x", Active: true}
+	db.Create(&user)
+
+	assert.True(t, db.Migrator().HasConstraint(&Task{}, "chk_tasks_priority"))
+	assert.Error(t, db.Create(&Task{Title: "Bad", Priority: "urgent", UserID: user.ID}).Error)
+	assert.NoError(t, db.Create(&Task{Title: "Good", Priority: "high", UserID: user.ID}).Error)
+
+	// Migrating again must leave the existing constraint alone
+	assert.NoError(t, db.AutoMigrate(&Task{}))
+}
+
+// TestGroupedTasks tests bucketing tasks by priority and completion
+func TestGroupedTasks(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "grouptestuser", Email: "grouptest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	db.Create(&[]Task{
+		{Title: "Urgent", Priority: "high", UserID: user.ID},
+		{Title: "Urgent done", Priority: "high", Completed: true, UserID: user.ID},
+		{Title: "Someday", Priority: "low", UserID: user.ID},
+	})
+
+	grouped := func(query string) (int, map[string][]Task) {
+		req, _ := http.NewRequest("GET", "/api/tasks/grouped"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Groups map[string][]Task `json:"groups"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Groups
+	}
+
+	code, groups := grouped("?by=priority")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, groups["high"], 2)
+	assert.Len(t, groups["low"], 1)
+	assert.NotNil(t, groups["medium"])
+	assert.Empty(t, groups["medium"])
+
+	code, groups = grouped("?by=completed&filter=" + url.QueryEscape("priority:high"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, groups["true"], 1)
+	assert.Len(t, groups["false"], 1)
+	assert.Equal(t, "Urgent", groups["false"][0].Title)
+
+	code, _ = grouped("?by=category")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// TestClientIDUpsert tests that creating with a known client ID updates instead
+func TestClientIDUpsert(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "upserttestuser", Email: "upserttest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	other := User{Username: "upsertotheruser", Email: "upsertother@example.com", Password: "x", Active: true}
+	db.Create(&other)
+	otherToken, _ := generateToken(other.ID)
+
+	create := func(token string, req TaskRequest) (int, Task) {
+		jsonData, _ := json.Marshal(req)
+		httpReq, _ := http.NewRequest("POST", "/api/tasks", bytes.NewBuffer(jsonData))
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httpReq)
+
+		var task Task
+		json.Unmarshal(w.Body.Bytes(), &task)
+		return w.Code, task
+	}
+
+	code, created := create(token, TaskRequest{Title: "Offline draft", ClientID: "c0ffee"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "c0ffee", *created.ClientID)
+
+	code, updated := create(token, TaskRequest{Title: "Offline final", Description: "Synced", ClientID: "c0ffee"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, created.ID, updated.ID)
+	assert.Equal(t, "Offline final", updated.Title)
+
+	var count int64
+	db.Model(&Task{}).Where("user_id = ? AND client_id = ?", user.ID, "c0ffee").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// Client IDs are scoped to the user
+	code, foreign := create(otherToken, TaskRequest{Title: "Same client ID", ClientID: "c0ffee"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.NotEqual(t, created.ID, foreign.ID)
+
+	// Tasks without a client ID are unaffected
+	code, _ = create(token, TaskRequest{Title: "Plain one"})
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = create(token, TaskRequest{Title: "Plain two"})
+	assert.Equal(t, http.StatusCreated, code)
+}
+
+// TestPrettyJSON tests indenting responses with ?pretty=true
+func TestPrettyJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(prettyJSONMiddleware())
+	r.GET("/api/ping", ping)
+
+	req, _ := http.NewRequest("GET", "/api/ping", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "\n")
+
+	req, _ = http.NewRequest("GET", "/api/ping?pretty=true", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "{\n    \"")
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, true, response["pong"])
+}
+
+// TestJSONNaming tests camelCase response keys
+func TestJSONNaming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(prettyJSONMiddleware())
+	r.Use(jsonNamingMiddleware(namingSnake))
+	r.GET("/profile", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"default_task_sort": "created_at_desc",
+			"tasks":             []gin.H{{"start_date": nil, "user_id": 12345678901}},
+			"fields":            gin.H{"title": "a <b> & c"},
+		})
+	})
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test snake_case stays the default
+	w := get("/profile", "")
+	assert.Contains(t, w.Body.String(), `"default_task_sort"`)
+
+	// Test the Accept hint switches to camelCase, nested keys included
+	w = get("/profile", "text/html, application/json; naming=camel")
+	assert.JSONEq(t, `{
+		"defaultTaskSort": "created_at_desc",
+		"tasks": [{"startDate": null, "userId": 12345678901}],
+		"fields": {"title": "a <b> & c"}
+	}`, w.Body.String())
+
+	// Test it composes with pretty printing
+	w = get("/profile?pretty=true", "application/json;naming=camel")
+	assert.Contains(t, w.Body.String(), "{\n    \"")
+	assert.Contains(t, w.Body.String(), `"defaultTaskSort"`)
+
+	// Test camel as the default, overridable per request
+	r = gin.New()
+	r.Use(jsonNamingMiddleware(namingCamel))
+	r.GET("/profile", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"last_login_at": "x"})
+	})
+	assert.JSONEq(t, `{"lastLoginAt": "x"}`, get("/profile", "").Body.String())
+	assert.JSONEq(t, `{"last_login_at": "x"}`, get("/profile", "application/json; naming=snake").Body.String())
+
+	t.Setenv("JSON_NAMING", "kebab")
+	_, err := getJSONNaming()
+	assert.Error(t, err)
+}
+
+// TestCookieAuth tests logging in and authenticating with the auth cookie
+func TestCookieAuth(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "AUTH_MODE", "cookie")
+	t.Setenv("BCRYPT_COST", "4")
+
+	password, _ := hashPassword("password123")
+	user := User{Username: "cookietestuser", Email: "cookietest@example.com", Password: password, Active: true}
+	db.Create(&user)
+
+	jsonData, _ := json.Marshal(map[string]string{"identifier": "cookietestuser", "password": "password123"})
+	req, _ := http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var loginResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &loginResponse)
+	assert.NotContains(t, loginResponse, "token")
+
+	var cookie, csrfCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case authCookieName:
+			cookie = c
+		case csrfCookieName:
+			csrfCookie = c
+		}
+	}
+	assert.NotNil(t, cookie)
+	assert.NotNil(t, csrfCookie)
+	assert.Equal(t, csrfCookie.Value, loginResponse["csrf_token"])
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+
+	// Test the cookie authenticates requests
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.AddCookie(cookie)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Test logout expires the cookie
+	req, _ = http.NewRequest("POST", "/api/logout", nil)
+	req.AddCookie(cookie)
+	req.AddCookie(csrfCookie)
+	req.Header.Set(csrfHeaderName, csrfCookie.Value)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+
+	// Test the cookie is ignored in bearer mode
+	setFeatureEnv(t, "AUTH_MODE", "bearer")
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.AddCookie(cookie)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestCSRFProtection tests double-submit CSRF checks for cookie-authenticated requests
+func TestCSRFProtection(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "AUTH_MODE", "cookie")
+
+	user := User{Username: "csrftestuser", Email: "csrftest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+	authCookie := &http.Cookie{Name: authCookieName, Value: token}
+
+	// Fetch a CSRF token
+	req, _ := http.NewRequest("GET", "/api/csrf-token", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var csrfResponse map[string]string
+	json.Unmarshal(w.Body.Bytes(), &csrfResponse)
+	csrfToken := csrfResponse["csrf_token"]
+	assert.NotEmpty(t, csrfToken)
+	csrfCookie := w.Result().Cookies()[0]
+	assert.Equal(t, csrfCookieName, csrfCookie.Name)
+	assert.Equal(t, csrfToken, csrfCookie.Value)
+
+	createTask := func(setup func(*http.Request)) int {
+		jsonData, _ := json.Marshal(TaskRequest{Title: "CSRF task"})
+		req, _ := http.NewRequest("POST", "/api/tasks", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		setup(req)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test a cookie request without the header is rejected
+	assert.Equal(t, http.StatusForbidden, createTask(func(req *http.Request) {
+		req.AddCookie(authCookie)
+		req.AddCookie(csrfCookie)
+	}))
+
+	// Test a mismatched header is rejected
+	assert.Equal(t, http.StatusForbidden, createTask(func(req *http.Request) {
+		req.AddCookie(authCookie)
+		req.AddCookie(csrfCookie)
+		req.Header.Set(csrfHeaderName, "forged")
+	}))
+
+	// Test a matching header is accepted
+	assert.Equal(t, http.StatusCreated, createTask(func(req *http.Request) {
+		req.AddCookie(authCookie)
+		req.AddCookie(csrfCookie)
+		req.Header.Set(csrfHeaderName, csrfToken)
+	}))
+
+	// Test bearer requests are exempt
+	assert.Equal(t, http.StatusCreated, createTask(func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}))
+
+	// Test safe methods are exempt
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.AddCookie(authCookie)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestModifiedSinceSync tests incremental sync with tombstones for removed tasks
+func TestModifiedSinceSync(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "synctestuser", Email: "synctest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	old := time.Now().Add(-time.Hour)
+	unchanged := Task{Title: "Unchanged", UserID: user.ID, CreatedAt: old, UpdatedAt: old}
+	db.Create(&unchanged)
+
+	since := time.Now().Add(-time.Minute)
+	changed := Task{Title: "Changed", UserID: user.ID}
+	doomed := Task{Title: "Doomed", UserID: user.ID}
+	db.Create(&changed)
+	db.Create(&doomed)
+
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/tasks/%d", doomed.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sync := func(since string) (*httptest.ResponseRecorder, map[uint]SyncTask, string) {
+		req, _ := http.NewRequest("GET", "/api/tasks?modified_since="+url.QueryEscape(since), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Tasks     []SyncTask `json:"tasks"`
+			SyncToken string     `json:"sync_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		byID := map[uint]SyncTask{}
+		for _, change := range response.Tasks {
+			byID[change.ID] = change
+		}
+		return w, byID, response.SyncToken
+	}
+
+	w, changes, syncToken := sync(since.Format(time.RFC3339Nano))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, syncToken, w.Header().Get("X-Sync-Token"))
+	assert.Len(t, changes, 2)
+	assert.False(t, changes[changed.ID].Deleted)
+	assert.Equal(t, "Changed", changes[changed.ID].Title)
+	assert.True(t, changes[doomed.ID].Deleted)
+	assert.NotContains(t, changes, unchanged.ID)
+
+	// Test the token is the latest change returned
+	tokenTime, err := time.Parse(time.RFC3339Nano, syncToken)
+	assert.NoError(t, err)
+	assert.False(t, tokenTime.Before(changed.UpdatedAt.Truncate(time.Microsecond)))
+
+	// Test a change stamped before the token but committed after the sync is
+	// still picked up, while older tasks are not re-sent
+	late := Task{Title: "Late", UserID: user.ID, UpdatedAt: tokenTime.Add(-time.Second)}
+	db.Create(&late)
+	w, changes, _ = sync(syncToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, changes, late.ID)
+	assert.NotContains(t, changes, unchanged.ID)
+
+	w, _, _ = sync("yesterday")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestMaintenanceMode tests that writes are blocked while reads and the toggle keep working
+func TestMaintenanceMode(t *testing.T) {
+	router := setupTestRouter()
+
+	hash, _ := hashPassword("password123")
+	admin := User{Username: "maintadmin", Email: "maintadmin@example.com", Password: hash, Active: true, IsAdmin: true}
+	db.Create(&admin)
+	token, _ := generateToken(admin.ID)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("PUT", "/api/admin/maintenance", `{"enabled": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	defer setMaintenance(false, admin.ID)
+
+	w = send("POST", "/api/tasks", `{"title": "Blocked"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "maintenance")
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	setFeatureEnv(t, "MAINTENANCE_RETRY_AFTER", "10m")
+	w = send("POST", "/api/tasks", `{"title": "Blocked"}`)
+	assert.Equal(t, "600", w.Header().Get("Retry-After"))
+
+	w = send("GET", "/api/tasks", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("GET", "/api/admin/maintenance", "")
+	assert.JSONEq(t, `{"enabled": true}`, w.Body.String())
+
+	// Test an admin can still log in and out to switch maintenance off
+	req, _ := http.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "maintadmin", "password": "password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "203.0.113.40:5000"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var loginResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &loginResponse)
+	token = loginResponse["token"].(string)
+
+	w = send("POST", "/api/logout", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Test confirming an email change is refused even though it is a GET
+	w = send("GET", "/api/confirm-email-change?token=anything", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	token, _ = generateToken(admin.ID)
+
+	w = send("PUT", "/api/admin/maintenance", `{"enabled": false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("POST", "/api/tasks", `{"title": "Allowed"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+// TestTaskDependencies tests adding dependencies, cycle detection and blocked completion
+func TestTaskDependencies(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "depsuser", Email: "deps@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	design := Task{Title: "Design", UserID: user.ID}
+	build := Task{Title: "Build", UserID: user.ID}
+	ship := Task{Title: "Ship", UserID: user.ID}
+	db.Create(&design)
+	db.Create(&build)
+	db.Create(&ship)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	depend := func(task, on Task) *httptest.ResponseRecorder {
+		return send("POST", fmt.Sprintf("/api/tasks/%d/dependencies", task.ID), fmt.Sprintf(`{"depends_on_id": %d}`, on.ID))
+	}
+
+	w := depend(build, design)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var resp Task
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, []uint{design.ID}, resp.DependsOn)
+
+	assert.Equal(t, http.StatusCreated, depend(ship, build).Code)
+	assert.Equal(t, http.StatusConflict, depend(ship, build).Code)
+	assert.Equal(t, http.StatusBadRequest, depend(ship, ship).Code)
+
+	// Test that ship -> build -> design -> ship is rejected
+	w = depend(design, ship)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "cycle")
+
+	// Test completion is blocked until dependencies are done
+	w = send("PUT", fmt.Sprintf("/api/tasks/%d", ship.ID), `{"title": "Ship", "completed": true}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var blocked struct {
+		BlockedBy []uint `json:"blocked_by"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &blocked)
+	assert.Equal(t, []uint{build.ID}, blocked.BlockedBy)
+
+	w = send("PUT", fmt.Sprintf("/api/tasks/%d", build.ID), `{"title": "Build", "completed": true}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = send("PUT", fmt.Sprintf("/api/tasks/%d", design.ID), `{"title": "Design", "completed": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("PUT", fmt.Sprintf("/api/tasks/%d", build.ID), `{"title": "Build", "completed": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("GET", fmt.Sprintf("/api/tasks/%d", ship.ID), "")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, []uint{build.ID}, resp.DependsOn)
+
+	w = send("DELETE", fmt.Sprintf("/api/tasks/%d/dependencies/%d", ship.ID, build.ID), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("DELETE", fmt.Sprintf("/api/tasks/%d/dependencies/%d", ship.ID, build.ID), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Test deleting a task drops its dependencies
+	send("DELETE", fmt.Sprintf("/api/tasks/%d", design.ID), "")
+	var remaining int64
+	db.Model(&TaskDependency{}).Where("depends_on_id = ?", design.ID).Count(&remaining)
+	assert.Zero(t, remaining)
+}
+
+// TestProfileIncludeTasks tests that recent tasks are only returned when asked for
+func TestProfileIncludeTasks(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "profiletasks", Email: "profiletasks@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < profileTaskLimit+2; i++ {
+		at := base.Add(time.Duration(i) * time.Minute)
+		db.Create(&Task{Title: fmt.Sprintf("Task %d", i), UserID: user.ID, CreatedAt: at, UpdatedAt: at})
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		req, _ := http.NewRequest("GET", "/api/profile"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var body map[string]json.RawMessage
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	w, body := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, body, "tasks")
+
+	w, body = get("?include=tasks")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tasks []Task
+	json.Unmarshal(body["tasks"], &tasks)
+	assert.Len(t, tasks, profileTaskLimit)
+	assert.Equal(t, fmt.Sprintf("Task %d", profileTaskLimit+1), tasks[0].Title)
+
+	w, _ = get("?include=invoices")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestPaginationConfig tests page sizes come from the environment and are validated
+func TestPaginationConfig(t *testing.T) {
+	t.Cleanup(func() { defaultPageSize, maxPageSize = defaultPerPage, maxPerPage })
+
+	t.Setenv("DEFAULT_PAGE_SIZE", "5")
+	t.Setenv("MAX_PAGE_SIZE", "8")
+	assert.NoError(t, configurePagination())
+	assert.Equal(t, 5, defaultPageSize)
+	assert.Equal(t, 8, maxPageSize)
+
+	parse := func(query string) Pagination {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/api/tasks"+query, nil)
+		p, _, err := parsePagination(c)
+		assert.NoError(t, err)
+		return p
+	}
+	assert.Equal(t, 5, parse("?page=1").PerPage)
+	assert.Equal(t, 8, parse("?per_page=50").PerPage)
+
+	invalid := map[string]string{
+		"DEFAULT_PAGE_SIZE": "0",
+		"MAX_PAGE_SIZE":     "lots",
+	}
+	for key, value := range invalid {
+		t.Setenv("DEFAULT_PAGE_SIZE", "")
+		t.Setenv("MAX_PAGE_SIZE", "")
+		t.Setenv(key, value)
+		assert.Error(t, configurePagination(), key)
+	}
+
+	t.Setenv("DEFAULT_PAGE_SIZE", "50")
+	t.Setenv("MAX_PAGE_SIZE", "10")
+	assert.Error(t, configurePagination())
+}
+
+// TestTaskColor tests setting, validating, keeping and clearing a task's color
+func TestTaskColor(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "colortestuser", Email: "colortest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	send := func(method, path, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := send("POST", "/api/tasks", `{"title": "Colored", "color": "#1a2B3c"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "#1a2B3c", response["color"])
+	path := fmt.Sprintf("/api/tasks/%v", response["id"])
+
+	for _, color := range []string{"red", "#FFF", "#12345G", "#1234567"} {
+		code, response = send("PUT", path, fmt.Sprintf(`{"title": "Colored", "color": %q}`, color))
+		assert.Equal(t, http.StatusBadRequest, code, color)
+		assert.Contains(t, response["fields"], "color", color)
+	}
+
+	// Test omitting the color keeps it
+	code, response = send("PUT", path, `{"title": "Renamed"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "#1a2B3c", response["color"])
+
+	code, response = send("PUT", path, `{"title": "Renamed", "color": ""}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, response, "color")
+}
+
+// TestPreloadHints tests Link preload headers are opt-in
+func TestPreloadHints(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "preloaduser", Email: "preload@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	profileLinks := func() []string {
+		req, _ := http.NewRequest("GET", "/api/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Values("Link")
+	}
+
+	assert.Empty(t, profileLinks())
+
+	setFeatureEnv(t, "PRELOAD_HINTS", "true")
+	assert.Equal(t, []string{taskListPreload}, profileLinks())
+
+	assert.NotContains(t, indexPreloadHints(), taskListPreload)
+	setFeatureEnv(t, "AUTH_MODE", "cookie")
+	assert.Contains(t, indexPreloadHints(), taskListPreload)
+	assert.Len(t, indexPreloads, 2)
+}
+
+// TestPasswordBreachCheck tests breached passwords are rejected and outages fail open
+func TestPasswordBreachCheck(t *testing.T) {
+	router := setupTestRouter()
+
+	sum := sha1.Sum([]byte("password123"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	var requested []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if r.URL.Path == "/range/"+hash[:5] {
+			fmt.Fprintf(w, "0000000000000000000000000000000000A:0\r\n%s:42\r\n", hash[5:])
+			return
+		}
+		fmt.Fprint(w, "0000000000000000000000000000000000A:3\r\n")
+	}))
+	defer api.Close()
+
+	setFeatureEnv(t, "PASSWORD_BREACH_CHECK", "true")
+	t.Setenv("PASSWORD_BREACH_API", api.URL+"/range/")
+
+	register := func(username, password string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"username": %q, "email": "%s@example.com", "password": %q}`, username, username, password)
+		req, _ := http.NewRequest("POST", "/api/register", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := register("breachuser1", "password123")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var rejected struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &rejected)
+	assert.Contains(t, rejected.Error, "breach")
+	assert.Contains(t, rejected.Fields["password"], "breach")
+	// Only the five character prefix is ever sent
+	assert.Equal(t, []string{"/range/" + hash[:5]}, requested)
+
+	w = register("breachuser2", "a much less common passphrase")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Test an unreachable API lets the signup through
+	api.Close()
+	w = register("breachuser3", "password123")
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+// TestUserRateLimit tests expensive endpoints are limited per user rather than per IP
+func TestUserRateLimit(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "USER_RATE_LIMIT", "2")
+
+	alice := User{Username: "ratelimitalice", Email: "ratealice@example.com", Password: "x", Active: true}
+	bob := User{Username: "ratelimitbob", Email: "ratebob@example.com", Password: "x", Active: true}
+	db.Create(&alice)
+	db.Create(&bob)
+	aliceToken, _ := generateToken(alice.ID)
+	bobToken, _ := generateToken(bob.ID)
+
+	search := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/search?q=anything", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, search(aliceToken).Code)
+	assert.Equal(t, http.StatusOK, search(aliceToken).Code)
+
+	w := search(aliceToken)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Same IP, different user
+	assert.Equal(t, http.StatusOK, search(bobToken).Code)
+
+	// Test the window resets and the sweep drops expired entries
+	store := newMemoryRateLimitStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	count, _, _ := store.Hit(context.Background(), "a", time.Minute)
+	assert.Equal(t, 1, count)
+	now = now.Add(10 * time.Second)
+	count, resetIn, _ := store.Hit(context.Background(), "a", time.Minute)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 50*time.Second, resetIn)
+
+	now = now.Add(2 * time.Minute)
+	count, _, _ = store.Hit(context.Background(), "b", time.Minute)
+	assert.Equal(t, 1, count)
+	assert.NotContains(t, store.windows, "a")
+
+	t.Setenv("LIMITER_BACKEND", "memcached")
+	assert.Error(t, configureRateLimitStore())
+
+	// Test an unreachable Redis is reported at startup rather than on first use
+	t.Setenv("LIMITER_BACKEND", "redis")
+	t.Setenv("REDIS_HOST", "127.0.0.1")
+	t.Setenv("REDIS_PORT", "1")
+	assert.Error(t, configureRateLimitStore())
+}
+
+// TestTransferTasks tests an admin moving a departing user's tasks to someone else
+func TestTransferTasks(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "transferadmin", Email: "transferadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	source := User{Username: "transfersource", Email: "transfersource@example.com", Password: "x", Active: true}
+	target := User{Username: "transfertarget", Email: "transfertarget@example.com", Password: "x", Active: true}
+	db.Create(&admin)
+	db.Create(&source)
+	db.Create(&target)
+	token, _ := generateToken(admin.ID)
+
+	// Same client ID on both sides must not collide after the move
+	clientID := "device-1"
+	db.Create(&Task{Title: "Handover", UserID: source.ID, ClientID: &clientID})
+	db.Create(&Task{Title: "Loose end", UserID: source.ID})
+	db.Create(&Task{Title: "Own task", UserID: target.ID, ClientID: &clientID})
+
+	transfer := func(sourceID, targetID uint) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"target_user_id": %d}`, targetID)
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%d/transfer-tasks", sourceID), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := transfer(source.ID, target.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Transferred int `json:"transferred"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, 2, response.Transferred)
+
+	var sourceCount, targetCount, tombstones int64
+	db.Model(&Task{}).Where("user_id = ?", source.ID).Count(&sourceCount)
+	db.Model(&Task{}).Where("user_id = ?", target.ID).Count(&targetCount)
+	db.Model(&TaskTombstone{}).Where("user_id = ?", source.ID).Count(&tombstones)
+	assert.Zero(t, sourceCount)
+	assert.Equal(t, int64(3), targetCount)
+	assert.Equal(t, int64(2), tombstones)
+
+	assert.Equal(t, http.StatusBadRequest, transfer(source.ID, source.ID).Code)
+	assert.Equal(t, http.StatusNotFound, transfer(source.ID, 999999).Code)
+}
+
+// TestRenderDescriptionHTML tests markdown rendering strips anything executable
+func TestRenderDescriptionHTML(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "renderuser", Email: "render@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	description := "**Bold** and [docs](https://example.com)\n\n" +
+		"<script>alert(1)</script>\n\n" +
+		"[click](javascript:alert(1)) <img src=x onerror=alert(1)>"
+	task := Task{Title: "Rendered", Description: description, UserID: user.ID}
+	db.Create(&task)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/tasks/%d%s", task.ID, query), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "description_html")
+
+	w = get("?render=html")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rendered RenderedTask
+	json.Unmarshal(w.Body.Bytes(), &rendered)
+	assert.Equal(t, description, rendered.Description)
+	assert.Contains(t, rendered.DescriptionHTML, "<strong>Bold</strong>")
+	assert.Contains(t, rendered.DescriptionHTML, `href="https://example.com"`)
+	for _, unsafe := range []string{"<script", "javascript:", "onerror"} {
+		assert.NotContains(t, rendered.DescriptionHTML, unsafe)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get("?render=pdf").Code)
+}
+
+// TestPatchTaskMergePatch tests RFC 7386 merge patches on tasks
+func TestPatchTaskMergePatch(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "patchuser", Email: "patch@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	start := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	task := Task{Title: "Original", Description: "Keep me", Priority: "low", StartDate: &start, Color: "#112233", UserID: user.ID}
+	db.Create(&task)
+
+	patch := func(contentType, body string) (*httptest.ResponseRecorder, Task) {
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/api/tasks/%d", task.ID), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var patched Task
+		json.Unmarshal(w.Body.Bytes(), &patched)
+		return w, patched
+	}
+
+	// Test absent keys are left alone
+	w, patched := patch(mergePatchContentType, `{"title": "Renamed", "priority": "high"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Renamed", patched.Title)
+	assert.Equal(t, "high", patched.Priority)
+	assert.Equal(t, "Keep me", patched.Description)
+	assert.Equal(t, "#112233", patched.Color)
+	assert.NotNil(t, patched.StartDate)
+
+	// Test null clears
+	w, patched = patch(mergePatchContentType, `{"description": null, "start_date": null, "color": null, "completed": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, patched.Description)
+	assert.Nil(t, patched.StartDate)
+	assert.Empty(t, patched.Color)
+	assert.True(t, patched.Completed)
+	assert.Equal(t, "Renamed", patched.Title)
+
+	unprocessable := []string{
+		`{"title": null}`,
+		`{"title": "   "}`,
+		`{"priority": "urgent"}`,
+		`{"title": 5}`,
+		`{"user_id": 1}`,
+		`{"color": "blue"}`,
+	}
+	for _, body := range unprocessable {
+		w, _ = patch(mergePatchContentType, body)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, body)
+	}
+
+	w, _ = patch("application/json", `{"title": "Wrong type"}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	w, _ = patch(mergePatchContentType, `["not", "an", "object"]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var stored Task
+	db.First(&stored, task.ID)
+	assert.Equal(t, "Renamed", stored.Title)
+	assert.Equal(t, "high", stored.Priority)
+
+	assert.Equal(t, map[string]interface{}{"a": "z", "c": map[string]interface{}{"d": "e"}},
+		mergePatch(map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e", "f": "g"}},
+			map[string]interface{}{"a": "z", "c": map[string]interface{}{"f": nil}}))
+}
+
+// TestRecentlyCompleted tests the recently done list ordering and limit
+func TestRecentlyCompleted(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "recentuser", Email: "recent@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	now := time.Now()
+	longAgo := now.Add(-48 * time.Hour)
+	earlier := now.Add(-time.Hour)
+	archived := now.Add(-time.Minute)
+	db.Create(&[]Task{
+		{Title: "Done long ago", Completed: true, CompletedAt: &longAgo, UserID: user.ID, UpdatedAt: now.Add(time.Minute)},
+		{Title: "Done just now", Completed: true, CompletedAt: &now, UserID: user.ID, UpdatedAt: now},
+		{Title: "Done earlier", Completed: true, CompletedAt: &earlier, UserID: user.ID, UpdatedAt: earlier},
+		{Title: "Still open", UserID: user.ID, UpdatedAt: now},
+		{Title: "Done and archived", Completed: true, CompletedAt: &now, ArchivedAt: &archived, UserID: user.ID, UpdatedAt: now},
+	})
+
+	get := func(query string) (*httptest.ResponseRecorder, []string) {
+		req, _ := http.NewRequest("GET", "/api/tasks/recent-completed"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var tasks []Task
+		json.Unmarshal(w.Body.Bytes(), &tasks)
+		titles := []string{}
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return w, titles
+	}
+
+	// Test edits after completion do not reorder the list
+	w, titles := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Done just now", "Done earlier", "Done long ago"}, titles)
+
+	_, titles = get("?limit=2")
+	assert.Equal(t, []string{"Done just now", "Done earlier"}, titles)
+
+	w, _ = get("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// setFeatureEnv sets a feature flag's variable for the test and reloads the
+// flags, reloading again once the variable is restored
+func setFeatureEnv(t *testing.T, key, value string) {
+	t.Cleanup(func() { reloadFeatures() })
+	t.Setenv(key, value)
+	reloadFeatures()
+}
+
+// TestFeatureFlags tests flags are loaded from the environment and listed for admins
+func TestFeatureFlags(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "featuresadmin", Email: "featuresadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	user := User{Username: "featuresuser", Email: "featuresuser@example.com", Password: "x", Active: true}
+	db.Create(&admin)
+	db.Create(&user)
+	adminToken, _ := generateToken(admin.ID)
+	userToken, _ := generateToken(user.ID)
+
+	assert.False(t, currentFeatures().InviteOnly)
+	t.Run("reload", func(t *testing.T) {
+		setFeatureEnv(t, "INVITE_ONLY", "true")
+		assert.True(t, currentFeatures().InviteOnly)
+	})
+	assert.False(t, currentFeatures().InviteOnly)
+
+	setFeatureEnv(t, "PASSWORD_BREACH_CHECK", "true")
+	setFeatureEnv(t, "USER_RATE_LIMIT_WINDOW", "90s")
+	setFeatureEnv(t, "TRUSTED_PROXIES", "10.0.0.1, 192.168.0.0/16")
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/admin/features", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(adminToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var flags map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &flags)
+	assert.Equal(t, true, flags["password_breach_check"])
+	assert.Equal(t, true, flags["registration_open"])
+	assert.Equal(t, false, flags["invite_only"])
+
+	// Test settings are listed too, durations as text
+	assert.Equal(t, "1m30s", flags["user_rate_window"])
+	assert.Equal(t, float64(defaultUserRateLimit), flags["user_rate_limit"])
+	assert.Equal(t, []interface{}{"10.0.0.1", "192.168.0.0/16"}, flags["trusted_proxies"])
+	assert.Len(t, currentFeatures().trustedProxyNets, 2)
+
+	assert.Equal(t, http.StatusForbidden, get(userToken).Code)
+}
+
+// TestGatewayAuth tests trusting the SSO gateway's identity header
+func TestGatewayAuth(t *testing.T) {
+	router := setupTestRouter()
+	setFeatureEnv(t, "AUTH_MODE", "gateway")
+	setFeatureEnv(t, "TRUSTED_PROXIES", "10.0.0.0/8")
+	t.Setenv("BCRYPT_COST", "4")
+
+	gatewayRequest := func(remoteAddr, username string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/profile", nil)
+		req.RemoteAddr = remoteAddr
+		if username != "" {
+			req.Header.Set(defaultGatewayUserHeader, username)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test the first request provisions the user
+	w := gatewayRequest("10.1.2.3:5000", "gatewayuser")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var profile User
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	assert.Equal(t, "gatewayuser", profile.Username)
+	assert.Equal(t, "gatewayuser@"+gatewayEmailDomain, profile.Email)
+
+	// Test later requests resolve to the same user
+	w = gatewayRequest("10.1.2.3:5000", "gatewayuser")
+	var again User
+	json.Unmarshal(w.Body.Bytes(), &again)
+	assert.Equal(t, profile.ID, again.ID)
+
+	// Test a gateway email is normalized before it is stored
+	req, _ := http.NewRequest("GET", "/api/profile", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set(defaultGatewayUserHeader, "gatewaymixed")
+	req.Header.Set(defaultGatewayEmailHeader, " Gateway.Mixed@Example.COM ")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"email":"gateway.mixed@example.com"`)
+
+	// Test lookup failures other than a missing user never provision one
+	type failLookupKey struct{}
+	db.Callback().Query().Before("gorm:query").Register("test:fail_lookup", func(tx *gorm.DB) {
+		if tx.Statement.Context.Value(failLookupKey{}) != nil {
+			tx.AddError(driver.ErrBadConn)
+		}
+	})
+	failing := context.WithValue(context.Background(), failLookupKey{}, true)
+	_, err := findOrCreateGatewayUser(failing, "gatewayfailed", "")
+	db.Callback().Query().Remove("test:fail_lookup")
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	var provisioned int64
+	db.Model(&User{}).Where("username = ?", "gatewayfailed").Count(&provisioned)
+	assert.Zero(t, provisioned)
+
+	// Test the header is not trusted from other peers
+	w = gatewayRequest("203.0.113.7:5000", "gatewayuser")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Test a missing header is rejected
+	w = gatewayRequest("10.1.2.3:5000", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Test batch sub-requests run as the gateway user
+	req, _ = http.NewRequest("POST", "/api/batch", strings.NewReader(`{"requests": [{"method": "GET", "path": "/api/profile"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(defaultGatewayUserHeader, "gatewayuser")
+	req.RemoteAddr = "10.1.2.3:5000"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var batched []BatchSubResponse
+	json.Unmarshal(w.Body.Bytes(), &batched)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, batched, 1)
+	assert.Equal(t, http.StatusOK, batched[0].Status)
+
+	// Test deactivated users are refused
+	db.Model(&User{}).Where("id = ?", profile.ID).Update("active", false)
+	w = gatewayRequest("10.1.2.3:5000", "gatewayuser")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Test gateway mode refuses to run without trusted proxies
+	setFeatureEnv(t, "TRUSTED_PROXIES", "")
+	assert.Error(t, checkGatewayConfig())
+}
+
+// TestWhoami tests the token introspection endpoint
+func TestWhoami(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "whoamiadminuser", Email: "whoamiadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	user := User{Username: "whoamitestuser", Email: "whoamitest@example.com", Password: "x", Active: true}
+	db.Create(&admin)
+	db.Create(&user)
+
+	whoamiRequest := func(token string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/api/auth/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	token, _ := generateToken(user.ID)
+	code, response := whoamiRequest(token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(user.ID), response["user_id"])
+	assert.Equal(t, "whoamitestuser", response["username"])
+	assert.Equal(t, scopeUser, response["scope"])
+	assert.NotContains(t, response, "impersonated_by")
+	assert.NotContains(t, response, "token")
+
+	claims, _ := parseToken(token)
+	expiresAt, err := time.Parse(time.RFC3339, response["expires_at"].(string))
+	assert.NoError(t, err)
+	assert.True(t, expiresAt.Equal(claims.ExpiresAt.Time))
+	assert.NotEmpty(t, response["issued_at"])
+
+	// Test impersonation tokens report their scope and admin
+	impToken, _, _ := generateImpersonationToken(user.ID, admin.ID)
+	code, response = whoamiRequest(impToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, scopeImpersonation, response["scope"])
+	assert.Equal(t, float64(admin.ID), response["impersonated_by"])
+
+	// Test an invalid token is rejected
+	code, _ = whoamiRequest("not-a-token")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+// TestCompletionHeatmap tests per-day completion counts for a year
+func TestCompletionHeatmap(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "heatmaptestuser", Email: "heatmaptest@example.com", Password: "x", Active: true}
+	other := User{Username: "heatmapotheruser", Email: "heatmapother@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	db.Create(&other)
+	token, _ := generateToken(user.ID)
+
+	march5 := time.Date(2024, time.March, 5, 10, 0, 0, 0, time.UTC)
+	march5Later := march5.Add(time.Hour)
+	march6 := march5.AddDate(0, 0, 1)
+	lastYear := march5.AddDate(-1, 0, 0)
+	db.Create(&[]Task{
+		{Title: "Done 1", UserID: user.ID, Completed: true, CompletedAt: &march5},
+		{Title: "Done 2", UserID: user.ID, Completed: true, CompletedAt: &march5Later},
+		{Title: "Done 3", UserID: user.ID, Completed: true, CompletedAt: &march6},
+		{Title: "Open", UserID: user.ID, UpdatedAt: march5},
+		{Title: "Edited since", UserID: user.ID, Completed: true, CompletedAt: &march6, UpdatedAt: march5},
+		{Title: "Last year", UserID: user.ID, Completed: true, CompletedAt: &lastYear},
+		{Title: "Not mine", UserID: other.ID, Completed: true, CompletedAt: &march5},
+	})
+
+	heatmapRequest := func(query string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/api/stats/heatmap"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := heatmapRequest("?year=2024")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(4), response["total"])
+
+	// Test edits after completion do not move a task to another day
+	days := response["days"].(map[string]interface{})
+	assert.Len(t, days, 366)
+	assert.Equal(t, float64(2), days["2024-03-05"])
+	assert.Equal(t, float64(2), days["2024-03-06"])
+	assert.Equal(t, float64(0), days["2024-12-31"])
+
+	// Test invalid years are rejected
+	for _, year := range []string{"abc", "1900", "10000"} {
+		code, _ = heatmapRequest("?year=" + year)
+		assert.Equal(t, http.StatusBadRequest, code, year)
+	}
+
+	// Test completing stamps completed_at once and reopening clears it
+	task := Task{Title: "Stamp me", UserID: user.ID}
+	db.Create(&task)
+	update := func(body string) Task {
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/api/tasks/%d", task.ID), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var updated Task
+		json.Unmarshal(w.Body.Bytes(), &updated)
+		return updated
+	}
+
+	completed := update(`{"title": "Stamp me", "completed": true}`)
+	assert.NotNil(t, completed.CompletedAt)
+	again := update(`{"title": "Stamp me again", "completed": true}`)
+	assert.True(t, completed.CompletedAt.Equal(*again.CompletedAt))
+	assert.Nil(t, update(`{"title": "Stamp me", "completed": false}`).CompletedAt)
+}
+
+// TestTaskTemplate tests the default description applied to new tasks
+func TestTaskTemplate(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "templatetestuser", Email: "templatetest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	sendJSON := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(data)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	template := "## Checklist\n- [ ] Step one\n- [ ] Step two"
+	code, response := sendJSON("PUT", "/api/profile", map[string]interface{}{"task_template": template + "\x07"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, template, response["task_template"])
+
+	// Test an omitted description uses the template
+	code, response = sendJSON("POST", "/api/tasks", map[string]interface{}{"title": "Templated"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, template, response["description"])
+
+	// Test explicit descriptions override it, even empty ones
+	code, response = sendJSON("POST", "/api/tasks", map[string]interface{}{"title": "Custom", "description": "Mine"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Mine", response["description"])
+
+	code, response = sendJSON("POST", "/api/tasks", map[string]interface{}{"title": "Blank", "description": ""})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "", response["description"])
+
+	// Test templates are held to the description limits
+	setFeatureEnv(t, "TASK_DESCRIPTION_MAX_LENGTH", "10")
+	code, _ = sendJSON("PUT", "/api/profile", map[string]interface{}{"task_template": "more than ten"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Test clearing the template
+	code, response = sendJSON("PUT", "/api/profile", map[string]interface{}{"task_template": ""})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "", response["task_template"])
+
+	code, response = sendJSON("POST", "/api/tasks", map[string]interface{}{"title": "Plain"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "", response["description"])
+}
+
+// TestTimeTracking tests estimates, logging time and the per-period totals
+func TestTimeTracking(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "timetestuser", Email: "timetest@example.com", Password: "x", Active: true}
+	other := User{Username: "timeotheruser", Email: "timeother@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	db.Create(&other)
+	token, _ := generateToken(user.ID)
+
+	sendJSON := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(data)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// Test estimates are set on create and must not be negative
+	code, response := sendJSON("POST", "/api/tasks", map[string]interface{}{"title": "Estimated", "estimate_minutes": 90})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, float64(90), response["estimate_minutes"])
+	assert.Equal(t, float64(0), response["actual_minutes"])
+	taskURL := fmt.Sprintf("/api/tasks/%v", response["id"])
+
+	code, _ = sendJSON("POST", "/api/tasks", map[string]interface{}{"title": "Negative", "estimate_minutes": -5})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Test logging time adds to the actual minutes
+	code, response = sendJSON("POST", taskURL+"/time", map[string]interface{}{"minutes": 30})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(30), response["actual_minutes"])
+
+	code, response = sendJSON("POST", taskURL+"/time", map[string]interface{}{"minutes": 45})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(75), response["actual_minutes"])
+	assert.Equal(t, float64(90), response["estimate_minutes"])
+
+	for _, minutes := range []int{0, -10, 1441} {
+		code, _ = sendJSON("POST", taskURL+"/time", map[string]interface{}{"minutes": minutes})
+		assert.Equal(t, http.StatusBadRequest, code, minutes)
+	}
+
+	// Test other users' tasks cannot be logged against
+	otherTask := Task{Title: "Not mine", UserID: other.ID}
+	db.Create(&otherTask)
+	code, _ = sendJSON("POST", fmt.Sprintf("/api/tasks/%d/time", otherTask.ID), map[string]interface{}{"minutes": 10})
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Test the stats sum effort per period
+	earlier := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC)
+	db.Create(&[]Task{
+		{Title: "January", UserID: user.ID, EstimateMinutes: 60, ActualMinutes: 20, CreatedAt: earlier},
+		{Title: "No time", UserID: user.ID, CreatedAt: earlier},
+		{Title: "Other user", UserID: other.ID, EstimateMinutes: 500, CreatedAt: earlier},
+	})
+
+	code, response = sendJSON("GET", "/api/stats/time?period=month", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	periods := response["periods"].([]interface{})
+	assert.Len(t, periods, 2)
+	first := periods[0].(map[string]interface{})
+	assert.Equal(t, "2024-01-01", first["start"])
+	assert.Equal(t, float64(60), first["estimate_minutes"])
+	assert.Equal(t, float64(20), first["actual_minutes"])
+	assert.Equal(t, float64(1), first["tasks"])
+
+	totals := response["totals"].(map[string]interface{})
+	assert.Equal(t, float64(150), totals["estimate_minutes"])
+	assert.Equal(t, float64(95), totals["actual_minutes"])
+
+	code, _ = sendJSON("GET", "/api/stats/time?period=year", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// TestLocalizedValidationMessages tests field errors in the client's language
+func TestLocalizedValidationMessages(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "localetestuser", Email: "localetest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	createTask := func(body, acceptLanguage string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", "/api/tasks", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	// Test struct tag failures are listed by JSON field name
+	w, response := createTask(`{"priority": "urgent"}`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+	fields := response["fields"].(map[string]interface{})
+	assert.Equal(t, "title is a required field", fields["title"])
+	assert.Contains(t, fields, "priority")
+
+	// Test Spanish is picked from Accept-Language, including regional variants
+	w, response = createTask(`{"priority": "urgent"}`, "es-MX,es;q=0.9,en;q=0.5")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "es", w.Header().Get("Content-Language"))
+	fields = response["fields"].(map[string]interface{})
+	assert.Equal(t, "title es un campo requerido", fields["title"])
+
+	// Test our own checks are translated too
+	_, response = createTask(`{"title": "   ", "color": "red"}`, "es")
+	fields = response["fields"].(map[string]interface{})
+	assert.Equal(t, "El título es obligatorio", fields["title"])
+	assert.Equal(t, "El color debe ser un color hexadecimal como #1A2B3C", fields["color"])
+
+	// Test unsupported locales fall back to English
+	w, response = createTask(`{"title": "   "}`, "de-DE,fr;q=0.8")
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+	fields = response["fields"].(map[string]interface{})
+	assert.Equal(t, "Title is required", fields["title"])
+}
+
+// TestNotificationPrefs tests reading and updating notification toggles
+func TestNotificationPrefs(t *testing.T) {
+	router := setupTestRouter()
+
+	admin := User{Username: "notifyadmin", Email: "notifyadmin@example.com", Password: "x", Active: true, IsAdmin: true}
+	user := User{Username: "notifyuser", Email: "notifyuser@example.com", Password: "x", Active: true}
+	db.Create(&admin)
+	db.Create(&user)
+	adminToken, _ := generateToken(admin.ID)
+	token, _ := generateToken(user.ID)
+	reminderTask := Task{Title: "Remind me", UserID: user.ID}
+	db.Create(&reminderTask)
+
+	send := func(method, path, body, token string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// Test everything starts on
+	code, response := send("GET", "/api/profile/notifications", "", token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"due_soon_reminders": true, "webhook_events": true, "digest": true}, response)
+
+	// Test a partial update leaves other toggles alone
+	code, response = send("PUT", "/api/profile/notifications", `{"due_soon_reminders": false, "digest": false}`, token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"due_soon_reminders": false, "webhook_events": true, "digest": false}, response)
+
+	_, response = send("GET", "/api/profile", "", token)
+	assert.Equal(t, false, response["notification_prefs"].(map[string]interface{})["digest"])
+
+	// Test unknown keys and non-boolean values are rejected
+	for _, body := range []string{`{"sms": true}`, `{"digest": "yes"}`, `{"digest": null}`, `[]`} {
+		code, _ = send("PUT", "/api/profile/notifications", body, token)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+
+	// Test the reminder and digest jobs skip users who opted out
+	req, _ := http.NewRequest("GET", "/api/admin/reminders", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "Remind me")
+
+	req, _ = http.NewRequest("GET", "/api/admin/digest", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "notifyadmin")
+	assert.NotContains(t, w.Body.String(), "notifyuser")
+}
+
+// TestValidateToken tests side-effect free token checks for gateways
+func TestValidateToken(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "validatetestuser", Email: "validatetest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	validate := func(body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", "/api/auth/validate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := validate(`{"token": "` + token + `"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["valid"])
+	assert.Equal(t, float64(user.ID), response["user_id"])
+	assert.NotEmpty(t, response["expires_at"])
+
+	// Test validation still works in maintenance mode
+	setMaintenance(true, 0)
+	code, _ = validate(`{"token": "` + token + `"}`)
+	setMaintenance(false, 0)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, response = validate(`{"token": "not-a-token"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, false, response["valid"])
+
+	code, _ = validate(`{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Test revoked tokens and deactivated users are refused
+	revokedAt := time.Now().Add(time.Minute)
+	db.Model(&user).Update("tokens_revoked_at", revokedAt)
+	code, _ = validate(`{"token": "` + token + `"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	db.Model(&user).Updates(map[string]interface{}{"tokens_revoked_at": nil, "active": false})
+	code, _ = validate(`{"token": "` + token + `"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+// TestTaskUUIDs tests tasks can be addressed by UUID instead of integer ID
+func TestTaskUUIDs(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "uuidtestuser", Email: "uuidtest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+
+	task := Task{Title: "UUID task", UserID: user.ID}
+	db.Create(&task)
+	assert.NotEmpty(t, task.UUID)
+
+	// Test tasks created before the column existed get one on startup
+	legacy := Task{Title: "Legacy task", UserID: user.ID}
+	db.Create(&legacy)
+	db.Model(&legacy).UpdateColumn("uuid", nil)
+	assert.NoError(t, backfillTaskUUIDs(context.Background()))
+	db.First(&legacy, legacy.ID)
+	assert.NotEmpty(t, legacy.UUID)
+
+	get := func(path string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// Test integer IDs are used by default
+	code, response := get(fmt.Sprintf("/api/tasks/%d", task.ID))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, task.UUID, response["uuid"])
+
+	// Test only UUIDs are accepted once enabled
+	setFeatureEnv(t, "USE_UUID_IDS", "true")
+
+	code, response = get("/api/tasks/" + task.UUID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "UUID task", response["title"])
+	assert.Equal(t, task.UUID, response["id"])
+
+	// Test body IDs take UUIDs too and responses never carry integer IDs
+	send := func(method, path, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response = send("POST", "/api/tasks/"+task.UUID+"/dependencies", `{"depends_on_id": "`+legacy.UUID+`"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, []interface{}{legacy.UUID}, response["depends_on"])
+
+	code, _ = send("POST", "/api/tasks/"+task.UUID+"/dependencies", fmt.Sprintf(`{"depends_on_id": %d}`, legacy.ID))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, response = send("PUT", "/api/tasks/"+task.UUID, `{"title": "UUID task", "completed": true}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, []interface{}{legacy.UUID}, response["blocked_by"])
+
+	code, response = send("POST", "/api/tasks/bulk-priority", `{"priority": "high", "ids": ["`+task.UUID+`", "`+legacy.UUID+`"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []interface{}{task.UUID, legacy.UUID}, response["ids"])
+
+	code, _ = get(fmt.Sprintf("/api/tasks/%d", task.ID))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = get("/api/tasks/00000000-0000-0000-0000-000000000000")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// TestExportUserData tests the data portability archive
+func TestExportUserData(t *testing.T) {
+	router := setupTestRouter()
+
+	user := User{Username: "dataexportuser", Email: "dataexport@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+	other := User{Username: "dataexportother", Email: "dataexportother@example.com", Password: "x", Active: true}
+	db.Create(&other)
+
+	first := Task{Title: "Export me", UserID: user.ID}
+	db.Create(&first)
+	archivedAt := time.Now()
+	second := Task{Title: "Archived export", UserID: user.ID, ArchivedAt: &archivedAt}
+	db.Create(&second)
+	db.Create(&TaskDependency{TaskID: second.ID, DependsOnID: first.ID, UserID: user.ID})
+	db.Create(&Task{Title: "Not mine", UserID: other.ID})
+
+	req, _ := http.NewRequest("GET", "/api/profile/export", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "dataexportuser-export-")
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		r, err := file.Open()
+		assert.NoError(t, err)
+		files[file.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	var profile map[string]interface{}
+	assert.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.Equal(t, "dataexport@example.com", profile["email"])
+	assert.NotContains(t, string(files["profile.json"]), "password")
+
+	// Test only the user's tasks are included, archived ones too
+	var tasks []Task
+	assert.NoError(t, json.Unmarshal(files["tasks.json"], &tasks))
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, "Export me", tasks[0].Title)
+	assert.Equal(t, []uint{first.ID}, tasks[1].DependsOn)
+
+	var manifest dataExportManifest
+	assert.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	assert.Equal(t, user.ID, manifest.UserID)
+	assert.Equal(t, []dataExportFile{{Name: "profile.json", Records: 1}, {Name: "tasks.json", Records: 2}}, manifest.Files)
+
+	// Test an account without tasks still gets a valid archive
+	otherToken, _ := generateToken(other.ID)
+	db.Where("user_id = ?", other.ID).Delete(&Task{})
+	req, _ = http.NewRequest("GET", "/api/profile/export", nil)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	archive, err = zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+	for _, file := range archive.File {
+		if file.Name == "tasks.json" {
+			r, _ := file.Open()
+			data, _ := io.ReadAll(r)
+			r.Close()
+			assert.NoError(t, json.Unmarshal(data, &tasks))
+			assert.Empty(t, tasks)
+		}
+	}
+}
+
+// TestAuthenticationMiddleware tests the authentication middleware
+func TestAuthenticationMiddleware(t *testing.T) {
+	router := setupTestRouter()
+
+	// Test without token
+	req, _ := http.NewRequest("GET", "/api/tasks", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Test with invalid token
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer invalid-token")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestProfileEndpoint tests the profile endpoint
+func TestProfileEndpoint(t *testing.T) {
+	router := setupTestRouter()
+
+	// Register and login to get token
+	registerData := map[string]interface{}{
+		"username": "profiletestuser",
+		"email":    "profiletest@example.com",
+		"password": "password123",
+	}
+
+	jsonData, _ := json.Marshal(registerData)
+	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Login to get token
+	loginData := map[string]interface{}{
+		"username": "profiletestuser",
+		"password": "password123",
+	}
+
+	jsonData, _ = json.Marshal(loginData)
+	req, _ = http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "203.0.113.7:54321"
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var loginResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &loginResponse)
+	token := loginResponse["token"].(string)
+
+	// Test get profile
+	req, _ = http.NewRequest("GET", "/api/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var profileResponse map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &profileResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, "profiletestuser", profileResponse["username"])
+	assert.Equal(t, "profiletest@example.com", profileResponse["email"])
+	assert.NotEmpty(t, profileResponse["last_login_at"])
+	assert.Equal(t, "203.0.113.7", profileResponse["last_login_ip"])
+}
+
+// TestEventHub tests task event fan-out to subscribers
+func TestEventHub(t *testing.T) {
+	hub := newEventHub()
+	go hub.run()
+	defer close(hub.broadcast)
+
+	ch := hub.subscribe(1)
+	other := hub.subscribe(2)
+
+	hub.publish(TaskCreated, Task{ID: 10, UserID: 1, Title: "Streamed Task"})
+
+	select {
+	case event := <-ch:
+		assert.Equal(t, TaskCreated, event.Type)
+		assert.Equal(t, uint(10), event.Task.ID)
+	case <-time.After(time.Second):
+		t.Fatal("expected event for subscribed user")
+	}
+
+	select {
+	case <-other:
+		t.Fatal("unexpected event for another user")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Unsubscribing closes the channel and drops the user entry
+	hub.unsubscribe(1, ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+	hub.mu.RLock()
+	_, exists := hub.subscribers[1]
+	hub.mu.RUnlock()
+	assert.False(t, exists)
+}
+
+// TestTaskSocket tests the WebSocket handshake and ping message
+func TestTaskSocket(t *testing.T) {
+	server := httptest.NewServer(setupTestRouter())
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws"
+
+	// Test without a valid token
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?token=invalid-token", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Test ping/pong with a valid token
+	user := User{Username: "sockettestuser", Email: "sockettest@example.com", Password: "x", Active: true}
+	db.Create(&user)
+	token, _ := generateToken(user.ID)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token="+token, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteJSON(SocketMessage{Type: "ping"}))
+
+	var reply map[string]interface{}
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Equal(t, "pong", reply["type"])
+}
+
+// TestUserDeactivation tests that deactivated users are locked out
+func TestUserDeactivation(t *testing.T) {
+	router := setupTestRouter()
+
+	adminPassword, _ := hashPassword("password123")
+	admin := User{Username: "admintestuser", Email: "admintest@example.com", Password: adminPassword, Active: true, IsAdmin: true}
+	db.Create(&admin)
+	adminToken, _ := generateToken(admin.ID)
+
+	// Register and login the user to suspend
+	registerData := map[string]interface{}{
+		"username": "suspendtestuser",
+		"email":    "suspendtest@example.com",
+		"password": "password123",
+	}
+
+	jsonData, _ := json.Marshal(registerData)
+	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var registerResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &registerResponse)
+	userID := registerResponse["user"].(map[string]interface{})["id"]
+
+	loginData := map[string]interface{}{
+		"username": "suspendtestuser",
+		"password": "password123",
+	}
+
+	jsonData, _ = json.Marshal(loginData)
+	req, _ = http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var loginResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &loginResponse)
+	token := loginResponse["token"].(string)
+
+	// Non-admins cannot deactivate users
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%v/deactivate", userID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Test deactivate
+	time.Sleep(time.Second)
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%v/deactivate", userID), nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Existing token is rejected
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Login is rejected
+	req, _ = http.NewRequest("POST", "/api/login", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Test reactivate
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%v/reactivate", userID), nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Old token stays revoked
+	req, _ = http.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Test a token issued in the same second as, but after, a revocation is
+	// accepted despite iat having no fraction
+	revokedAt := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	db.Model(&User{}).Where("id = ?", userID).Update("tokens_revoked_at", revokedAt)
+	claims := &Claims{UserID: uint(userID.(float64)), RegisteredClaims: jwt.RegisteredClaims{
+		IssuedAt: jwt.NewNumericDate(revokedAt.Add(100 * time.Millisecond)),
+	}}
+	_, err := authorizeClaims(context.Background(), claims)
+	assert.NoError(t, err)
+
+	claims.IssuedAt = jwt.NewNumericDate(revokedAt.Add(-time.Second))
+	_, err = authorizeClaims(context.Background(), claims)
+	assert.Error(t, err)
+}
+
+// TestSetRetryAfter tests that backoffs round up to whole seconds
+func TestSetRetryAfter(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:                       "1",
+		200 * time.Millisecond:  "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60",
+	}
+	for wait, expected := range cases {
+		header := http.Header{}
+		setRetryAfter(header, wait)
+		assert.Equal(t, expected, header.Get("Retry-After"), wait.String())
+	}
+}
+
+// TestTimeoutMiddleware tests that slow handlers are answered with 503
+func TestTimeoutMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(timeoutMiddleware(20 * time.Millisecond))
+	router.GET("/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tasks"})
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	req, _ := http.NewRequest("GET", "/slow", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"error":"Request timed out"}`, w.Body.String())
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	req, _ = http.NewRequest("GET", "/fast", nil)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestTracingMiddleware tests that incoming trace context is continued
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := gin.New()
+	router.Use(tracingMiddleware())
+	router.GET("/traced/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req, _ := http.NewRequest("GET", "/traced/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "GET /traced/:id", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
+
+// TestTracingPlugin tests that queries chained on one statement get sibling spans
+func TestTracingPlugin(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	traced, err := gorm.Open(db.Dialector, &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, traced.Use(tracingPlugin{}))
+
+	ctx, request := otel.Tracer(tracerName).Start(context.Background(), "request")
+	var total int64
+	var tasks []Task
+	query := traced.WithContext(ctx).Model(&Task{}).Where("user_id = ?", 0)
+	query.Count(&total)
+	query.Find(&tasks)
+	request.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	for _, span := range spans[:2] {
+		assert.Equal(t, "gorm.query", span.Name())
+		assert.Equal(t, request.SpanContext().SpanID(), span.Parent().SpanID())
+	}
 }
 
 // Benchmark tests for performance
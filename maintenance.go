@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMaintenanceRetryAfter is the backoff suggested to writers during
+// maintenance, overridable with MAINTENANCE_RETRY_AFTER
+const defaultMaintenanceRetryAfter = time.Minute
+
+// maintenanceExemptRoutes stay writable during maintenance: the toggle and
+// login/logout so an admin whose token expired can still switch it off, token
+// validation since it only reads, and batches since each sub-request is
+// checked on its own
+var maintenanceExemptRoutes = map[string]bool{
+	"/api/admin/maintenance": true,
+	"/api/login":             true,
+	"/api/logout":            true,
+	"/api/auth/validate":     true,
+	"/api/batch":             true,
+}
+
+// Maintenance state starts from MAINTENANCE_MODE and can be flipped at runtime
+var (
+	maintenanceMu      sync.RWMutex
+	maintenanceOnce    sync.Once
+	maintenanceEnabled bool
+)
+
+// MaintenanceRequest switches read-only maintenance mode on or off
+type MaintenanceRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// loadMaintenance reads MAINTENANCE_MODE the first time the mode is needed
+func loadMaintenance() {
+	maintenanceOnce.Do(func() {
+		maintenanceEnabled = currentFeatures().MaintenanceMode
+		if maintenanceEnabled {
+			slog.Warn("Maintenance mode enabled", "source", "env")
+		}
+	})
+}
+
+// inMaintenance reports whether writes are currently blocked
+func inMaintenance() bool {
+	loadMaintenance()
+
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+	return maintenanceEnabled
+}
+
+// setMaintenance changes the mode, logging only actual transitions
+func setMaintenance(enabled bool, adminID uint) {
+	loadMaintenance()
+
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	if maintenanceEnabled == enabled {
+		return
+	}
+	maintenanceEnabled = enabled
+
+	if enabled {
+		slog.Warn("Maintenance mode enabled", "source", "admin", "admin_id", adminID)
+	} else {
+		slog.Warn("Maintenance mode disabled", "source", "admin", "admin_id", adminID)
+	}
+}
+
+// maintenanceMiddleware rejects writes with a 503 while maintenance mode is on
+func maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if maintenanceExemptRoutes[c.FullPath()] || !rejectDuringMaintenance(c) {
+			c.Next()
+		}
+	}
+}
+
+// rejectDuringMaintenance responds with a 503 if maintenance mode is on, for
+// handlers that write despite being reached with a GET
+func rejectDuringMaintenance(c *gin.Context) bool {
+	if !inMaintenance() {
+		return false
+	}
+
+	setRetryAfter(c.Writer.Header(), currentFeatures().MaintenanceRetryAfter)
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":       "The service is in read-only maintenance mode, please try again later",
+		"maintenance": true,
+	})
+	return true
+}
+
+// getMaintenance reports whether maintenance mode is on
+func getMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": inMaintenance()})
+}
+
+// updateMaintenance turns maintenance mode on or off
+func updateMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	setMaintenance(*req.Enabled, c.GetUint("user_id"))
+	c.JSON(http.StatusOK, gin.H{"enabled": *req.Enabled})
+}
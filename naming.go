@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSON key styles for responses. Structs are tagged in snake_case, so camel
+// is produced by rewriting keys on the way out.
+const (
+	namingSnake = "snake"
+	namingCamel = "camel"
+)
+
+// getJSONNaming reads JSON_NAMING, defaulting to snake_case
+func getJSONNaming() (string, error) {
+	switch naming := strings.ToLower(getEnv("JSON_NAMING", namingSnake)); naming {
+	case namingSnake, namingCamel:
+		return naming, nil
+	default:
+		return "", fmt.Errorf("invalid JSON_NAMING %q, must be snake or camel", naming)
+	}
+}
+
+// acceptedNaming returns the naming a client asked for with a media type
+// parameter such as "Accept: application/json; naming=camel", if any
+func acceptedNaming(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch naming := strings.ToLower(params["naming"]); naming {
+		case namingSnake, namingCamel:
+			return naming
+		}
+	}
+	return ""
+}
+
+// jsonNamingMiddleware rewrites JSON response keys to camelCase when the
+// default naming is camel or the request's Accept header asks for it. Only
+// output changes; request bodies are still bound with the snake_case names.
+// Map keys are rewritten too, which is harmless for the API's own maps
+// since none of their keys are user data containing underscores.
+func jsonNamingMiddleware(defaultNaming string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		naming := defaultNaming
+		if requested := acceptedNaming(c.GetHeader("Accept")); requested != "" {
+			naming = requested
+		}
+		if naming != namingCamel || streamingRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		nw := &namingWriter{ResponseWriter: c.Writer}
+		c.Writer = nw
+
+		c.Next()
+
+		c.Writer = nw.ResponseWriter
+		nw.flush()
+	}
+}
+
+// namingWriter holds the response body back so its keys can be rewritten
+type namingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *namingWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *namingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// flush writes the buffered body, camelCased if it is JSON
+func (w *namingWriter) flush() {
+	if w.body.Len() == 0 {
+		return
+	}
+
+	if strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		if converted, err := camelCaseJSON(w.body.Bytes()); err == nil {
+			w.ResponseWriter.Write(converted)
+			return
+		}
+	}
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// snakeToCamel turns default_task_sort into defaultTaskSort
+func snakeToCamel(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// jsonFrame tracks an open object or array while re-encoding
+type jsonFrame struct {
+	object    bool
+	expectKey bool
+	count     int
+}
+
+// camelCaseJSON re-encodes a JSON document with every object key camelCased.
+// It walks the token stream so key order and number formatting survive.
+func camelCaseJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var out bytes.Buffer
+	var stack []*jsonFrame
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if top != nil && top.object && top.expectKey {
+			if top.count > 0 {
+				out.WriteByte(',')
+			}
+			top.count++
+			top.expectKey = false
+
+			key, err := json.Marshal(snakeToCamel(tok.(string)))
+			if err != nil {
+				return nil, err
+			}
+			out.Write(key)
+			out.WriteByte(':')
+			continue
+		}
+
+		if top != nil {
+			if top.object {
+				top.expectKey = true
+			} else {
+				if top.count > 0 {
+					out.WriteByte(',')
+				}
+				top.count++
+			}
+		}
+
+		switch value := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(value))
+			stack = append(stack, &jsonFrame{object: value == '{', expectKey: value == '{'})
+		case json.Number:
+			out.WriteString(value.String())
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(encoded)
+		}
+	}
+	return out.Bytes(), nil
+}
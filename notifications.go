@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationPrefs are a user's notification toggles. Everything starts on,
+// so existing users keep getting what they got before preferences existed.
+type NotificationPrefs struct {
+	DueSoonReminders bool `json:"due_soon_reminders" gorm:"not null;default:true"`
+	WebhookEvents    bool `json:"webhook_events" gorm:"not null;default:true"`
+	Digest           bool `json:"digest" gorm:"not null;default:true"`
+}
+
+// notificationPrefSetters applies each known preference key; the keys double
+// as the whitelist for updates
+var notificationPrefSetters = map[string]func(*NotificationPrefs, bool){
+	"due_soon_reminders": func(p *NotificationPrefs, v bool) { p.DueSoonReminders = v },
+	"webhook_events":     func(p *NotificationPrefs, v bool) { p.WebhookEvents = v },
+	"digest":             func(p *NotificationPrefs, v bool) { p.Digest = v },
+}
+
+// getNotificationPrefs returns the user's notification preferences
+func getNotificationPrefs(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.NotificationPrefs)
+}
+
+// updateNotificationPrefs changes the preferences present in the body and
+// leaves the rest alone. Unknown keys are rejected so a typo cannot silently
+// leave a notification on.
+func updateNotificationPrefs(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	if !isJSONContentType(c.ContentType()) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/json"})
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	var changes map[string]json.RawMessage
+	if err := json.Unmarshal(body, &changes); err != nil || changes == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Notification preferences must be a JSON object"})
+		return
+	}
+
+	values := make(map[string]bool, len(changes))
+	for key, raw := range changes {
+		if _, ok := notificationPrefSetters[key]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown notification preference %q", key)})
+			return
+		}
+		var value *bool
+		if err := json.Unmarshal(raw, &value); err != nil || value == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Notification preference %q must be true or false", key)})
+			return
+		}
+		values[key] = *value
+	}
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	for key, value := range values {
+		notificationPrefSetters[key](&user.NotificationPrefs, value)
+	}
+
+	user.UpdatedAt = time.Now()
+
+	if err := db.WithContext(c.Request.Context()).Save(&user).Error; err != nil {
+		respondDBError(c, err, "Failed to update notification preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, user.NotificationPrefs)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Built-in pagination limits, overridable with DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// Page sizes in effect, set from the environment by configurePagination
+var (
+	defaultPageSize = defaultPerPage
+	maxPageSize     = maxPerPage
+)
+
+// configurePagination reads DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE, rejecting
+// values that are not positive or a default above the maximum
+func configurePagination() error {
+	defaultSize, err := pageSizeEnv("DEFAULT_PAGE_SIZE", defaultPerPage)
+	if err != nil {
+		return err
+	}
+	maxSize, err := pageSizeEnv("MAX_PAGE_SIZE", maxPerPage)
+	if err != nil {
+		return err
+	}
+	if defaultSize > maxSize {
+		return fmt.Errorf("DEFAULT_PAGE_SIZE %d exceeds MAX_PAGE_SIZE %d", defaultSize, maxSize)
+	}
+
+	defaultPageSize, maxPageSize = defaultSize, maxSize
+	return nil
+}
+
+func pageSizeEnv(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, value)
+	}
+	return size, nil
+}
+
+// Pagination holds the requested page window
+type Pagination struct {
+	Page    int
+	PerPage int
+}
+
+// Offset returns the number of rows to skip
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// parsePagination reads page and per_page from the query string; ok is false
+// when the client did not ask for pagination
+func parsePagination(c *gin.Context) (p Pagination, ok bool, err error) {
+	pageStr, hasPage := c.GetQuery("page")
+	perPageStr, hasPerPage := c.GetQuery("per_page")
+	if !hasPage && !hasPerPage {
+		return Pagination{}, false, nil
+	}
+
+	p = Pagination{Page: 1, PerPage: defaultPageSize}
+
+	if hasPage {
+		if p.Page, err = strconv.Atoi(pageStr); err != nil || p.Page < 1 {
+			return Pagination{}, true, errors.New("Invalid page")
+		}
+	}
+
+	if hasPerPage {
+		if p.PerPage, err = strconv.Atoi(perPageStr); err != nil || p.PerPage < 1 {
+			return Pagination{}, true, errors.New("Invalid per_page")
+		}
+		if p.PerPage > maxPageSize {
+			p.PerPage = maxPageSize
+		}
+	}
+
+	return p, true, nil
+}
+
+// setPaginationHeaders writes X-Total-Count, X-Page, X-Per-Page and an RFC 8288 Link header
+func setPaginationHeaders(c *gin.Context, p Pagination, total int64) {
+	lastPage := int((total + int64(p.PerPage) - 1) / int64(p.PerPage))
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	c.Header("X-Page", strconv.Itoa(p.Page))
+	c.Header("X-Per-Page", strconv.Itoa(p.PerPage))
+
+	pageURL := func(page int) string {
+		u := *c.Request.URL
+		q := u.Query()
+		q.Set("page", strconv.Itoa(page))
+		q.Set("per_page", strconv.Itoa(p.PerPage))
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(1))}
+	if p.Page > 1 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(p.Page-1)))
+	}
+	if p.Page < lastPage {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(p.Page+1)))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="last"`, pageURL(lastPage)))
+
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// taskCursor is the position after the last task of a page. Paging by
+// (created_at, id) rather than an offset stays stable while tasks are added
+// or removed between requests.
+type taskCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        uint      `json:"id"`
+}
+
+// encodeTaskCursor makes an opaque cursor pointing after task
+func encodeTaskCursor(task Task) string {
+	data, _ := json.Marshal(taskCursor{CreatedAt: task.CreatedAt, ID: task.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeTaskCursor reads a cursor produced by encodeTaskCursor
+func decodeTaskCursor(value string) (taskCursor, error) {
+	var cursor taskCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.ID == 0 {
+		return taskCursor{}, errors.New("Invalid cursor")
+	}
+	return cursor, nil
+}
+
+// setCursorHeaders writes X-Per-Page and, when there are more tasks,
+// X-Next-Cursor with a matching rel="next" Link
+func setCursorHeaders(c *gin.Context, perPage int, next string) {
+	c.Header("X-Per-Page", strconv.Itoa(perPage))
+	if next == "" {
+		return
+	}
+
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set("cursor", next)
+	q.Set("per_page", strconv.Itoa(perPage))
+	u.RawQuery = q.Encode()
+
+	c.Header("X-Next-Cursor", next)
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
+
+// cursorPage wraps a cursor-paged list so clients that never read headers
+// can follow next_cursor, which is null on the last page
+func cursorPage(tasks interface{}, next string) gin.H {
+	page := gin.H{"tasks": tasks, "next_cursor": nil}
+	if next != "" {
+		page["next_cursor"] = next
+	}
+	return page
+}
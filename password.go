@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHasher is implemented by each supported hash algorithm. Hashes
+// carry their algorithm prefix so verification can pick the implementation.
+type passwordHasher interface {
+	// Matches reports whether the hash was produced by this algorithm
+	Matches(hash string) bool
+	Hash(password string) (string, error)
+	Verify(password, hash string) bool
+	// Outdated reports whether the hash uses weaker parameters than configured
+	Outdated(hash string) bool
+}
+
+// Supported password hashers keyed by HASH_ALGO value
+var passwordHashers = map[string]passwordHasher{
+	"bcrypt":   bcryptHasher{},
+	"argon2id": argon2idHasher{},
+}
+
+// currentHasher returns the hasher selected by HASH_ALGO, defaulting to bcrypt
+func currentHasher() passwordHasher {
+	if hasher, ok := passwordHashers[currentFeatures().HashAlgo]; ok {
+		return hasher
+	}
+	return bcryptHasher{}
+}
+
+// hasherFor returns the hasher that produced the given hash
+func hasherFor(hash string) passwordHasher {
+	for _, hasher := range passwordHashers {
+		if hasher.Matches(hash) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	return currentHasher().Hash(password)
+}
+
+func checkPassword(password, hash string) bool {
+	hasher := hasherFor(hash)
+	return hasher != nil && hasher.Verify(password, hash)
+}
+
+// needsRehash reports whether a hash should be replaced on next login, either
+// because the configured algorithm changed or its cost was raised
+func needsRehash(hash string) bool {
+	current := currentHasher()
+	if !current.Matches(hash) {
+		return hasherFor(hash) != nil
+	}
+	return current.Outdated(hash)
+}
+
+// getBcryptCost returns the bcrypt cost from BCRYPT_COST or the default
+func getBcryptCost() int {
+	cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return 14
+	}
+	return cost
+}
+
+type bcryptHasher struct{}
+
+func (bcryptHasher) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (bcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), getBcryptCost())
+	return string(bytes), err
+}
+
+func (bcryptHasher) Verify(password, hash string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+func (bcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < getBcryptCost()
+}
+
+// argon2id parameters, following the RFC 9106 recommendations
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+const argon2Prefix = "$argon2id$"
+
+// argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+type argon2idHasher struct{}
+
+func (argon2idHasher) Matches(hash string) bool {
+	return strings.HasPrefix(hash, argon2Prefix)
+}
+
+func (argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2Params holds the parameters decoded from a stored hash
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func decodeArgon2(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (argon2idHasher) Verify(password, hash string) bool {
+	p, err := decodeArgon2(hash)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1
+}
+
+func (argon2idHasher) Outdated(hash string) bool {
+	p, err := decodeArgon2(hash)
+	if err != nil {
+		return false
+	}
+	return p.memory < argon2Memory || p.time < argon2Time
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// mergePatchContentType is the RFC 7386 media type accepted by PATCH
+const mergePatchContentType = "application/merge-patch+json"
+
+// Fields a merge patch may change, and whether null is allowed to clear them
+var patchableTaskFields = map[string]bool{
+	"title":            false,
+	"description":      true,
+	"priority":         false,
+	"start_date":       true,
+	"completed":        false,
+	"color":            true,
+	"estimate_minutes": false,
+}
+
+// mergePatch applies an RFC 7386 merge patch to target and returns the result
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// patchableDocument is the editable part of a task as a JSON object
+func patchableDocument(task Task) map[string]interface{} {
+	doc := map[string]interface{}{
+		"title":            task.Title,
+		"description":      task.Description,
+		"priority":         task.Priority,
+		"completed":        task.Completed,
+		"color":            task.Color,
+		"estimate_minutes": task.EstimateMinutes,
+	}
+	if task.StartDate != nil {
+		doc["start_date"] = task.StartDate
+	}
+
+	// Round-trip so values compare and merge as decoded JSON
+	data, _ := json.Marshal(doc)
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	return decoded
+}
+
+// patchTask applies a JSON merge patch to a task: keys set to null are
+// cleared and absent keys keep their value. The patched task must still pass
+// the usual validation, otherwise the response is 422.
+func patchTask(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != mergePatchContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + mergePatchContentType})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Merge patch must be a JSON object"})
+		return
+	}
+
+	for key, value := range patch {
+		nullable, ok := patchableTaskFields[key]
+		if !ok {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Field %q cannot be patched", key)})
+			return
+		}
+		if value == nil && !nullable {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Field %q cannot be cleared", key)})
+			return
+		}
+	}
+
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
+		return
+	}
+
+	// Decode the merged document the same way a full update is bound
+	merged, _ := json.Marshal(mergePatch(patchableDocument(task), patch))
+	var req TaskRequest
+	if err := json.Unmarshal(merged, &req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid request data"})
+		return
+	}
+	setupValidationTranslations()
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid request data", "fields": validationFields(c, err)})
+		return
+	}
+	if req.Color == nil {
+		req.Color = new(string)
+	}
+	// The merged document is the whole editable task, so a start_date it
+	// lacks was cleared by the patch
+	req.startDateSet = true
+	if !checkTaskRequest(c, &req, http.StatusUnprocessableEntity) {
+		return
+	}
+	if !checkCompletionAllowed(c, task, req) {
+		return
+	}
+
+	applyTaskRequest(&task, req)
+
+	if err := db.WithContext(c.Request.Context()).Save(&task).Error; err != nil {
+		respondDBError(c, err, "Failed to update task")
+		return
+	}
+	tasks := []Task{task}
+	if err := loadTaskDependencies(c.Request.Context(), tasks); err != nil {
+		respondDBError(c, err, "Failed to update task")
+		return
+	}
+
+	events.publish(TaskUpdated, tasks[0])
+
+	c.JSON(http.StatusOK, tasks[0])
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Assets the index page loads on every visit
+var indexPreloads = []string{
+	"</static/css/style.css>; rel=preload; as=style",
+	"</static/js/app.js>; rel=preload; as=script",
+}
+
+// taskListPreload hints the first API call the web app makes
+const taskListPreload = "</api/tasks>; rel=preload; as=fetch; crossorigin=use-credentials"
+
+// setPreloadHints adds a Link header per hint when preload hints are enabled
+func setPreloadHints(c *gin.Context, hints ...string) {
+	if !currentFeatures().PreloadHints {
+		return
+	}
+	for _, hint := range hints {
+		c.Writer.Header().Add("Link", hint)
+	}
+}
+
+// indexPreloadHints are the hints for the HTML entry point. The task list is
+// only worth preloading in cookie mode; a preload cannot carry a bearer token.
+func indexPreloadHints() []string {
+	if cookieAuth() {
+		return append(indexPreloads[:len(indexPreloads):len(indexPreloads)], taskListPreload)
+	}
+	return indexPreloads
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prettyJSONMiddleware indents JSON responses when the request has
+// ?pretty=true, or by default when PRETTY_JSON is set. Output is buffered so
+// it stays off unless asked for.
+func prettyJSONMiddleware() gin.HandlerFunc {
+	defaultPretty := currentFeatures().PrettyJSON
+
+	return func(c *gin.Context) {
+		pretty := defaultPretty
+		if value, ok := c.GetQuery("pretty"); ok {
+			pretty = value == "true" || value == "1"
+		}
+		if !pretty || streamingRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		pw := &prettyWriter{ResponseWriter: c.Writer}
+		c.Writer = pw
+
+		c.Next()
+
+		c.Writer = pw.ResponseWriter
+		pw.flush()
+	}
+}
+
+// prettyWriter holds the response body back so it can be indented
+type prettyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *prettyWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *prettyWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// flush writes the buffered body, indented if it is JSON
+func (w *prettyWriter) flush() {
+	if w.body.Len() == 0 {
+		return
+	}
+
+	if strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, w.body.Bytes(), "", "    "); err == nil {
+			w.ResponseWriter.Write(indented.Bytes())
+			return
+		}
+	}
+	w.ResponseWriter.Write(w.body.Bytes())
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Per-user limits for expensive endpoints, overridable with USER_RATE_LIMIT
+// and USER_RATE_LIMIT_WINDOW
+const (
+	defaultUserRateLimit  = 30
+	defaultUserRateWindow = time.Minute
+)
+
+// rateLimitStore counts hits per key in fixed windows. The in-memory store
+// suits a single node; the Redis store shares counts across replicas.
+type rateLimitStore interface {
+	// Hit records a request against key, returning the number of hits in the
+	// current window and how long until that window resets
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+}
+
+// Store used by every rate-limited route, chosen by LIMITER_BACKEND
+var rateLimits rateLimitStore = newMemoryRateLimitStore()
+
+// configureRateLimitStore selects the limiter backend: memory (default) or redis
+func configureRateLimitStore() error {
+	switch backend := strings.ToLower(getEnv("LIMITER_BACKEND", "memory")); backend {
+	case "memory":
+		rateLimits = newMemoryRateLimitStore()
+	case "redis":
+		store, err := newRedisRateLimitStore()
+		if err != nil {
+			return err
+		}
+		rateLimits = store
+	default:
+		return fmt.Errorf("unsupported LIMITER_BACKEND %q, must be memory or redis", backend)
+	}
+	return nil
+}
+
+// rateWindow counts one key's hits in the current fixed window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// memoryRateLimitStore keeps windows in process memory. Expired windows are
+// swept as hits come in, so idle keys do not pile up.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{windows: make(map[string]*rateWindow), now: time.Now}
+}
+
+func (s *memoryRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= window {
+		for k, w := range s.windows {
+			if now.Sub(w.start) >= window {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || now.Sub(w.start) >= window {
+		w = &rateWindow{start: now}
+		s.windows[key] = w
+	}
+	w.count++
+
+	return w.count, w.start.Add(window).Sub(now), nil
+}
+
+// getUserRateLimit reads USER_RATE_LIMIT; 0 turns per-user limiting off
+func getUserRateLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("USER_RATE_LIMIT"))
+	if err != nil || limit < 0 {
+		return defaultUserRateLimit
+	}
+	return limit
+}
+
+// userRateLimit limits how often the authenticated user may call a route,
+// complementing IP-based limits that cannot tell users behind one NAT apart.
+// It must run after authMiddleware.
+func userRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := currentFeatures().UserRateLimit
+		if limit == 0 {
+			c.Next()
+			return
+		}
+
+		key := fmt.Sprintf("user:%d", c.GetUint("user_id"))
+		count, resetIn, err := rateLimits.Hit(c.Request.Context(), key, currentFeatures().UserRateWindow)
+		if err != nil {
+			// An unreachable store should not take the API down with it
+			slog.Warn("Rate limit check failed, allowing request", "key", key, "error", err)
+			c.Next()
+			return
+		}
+
+		if count > limit {
+			setRetryAfter(c.Writer.Header(), resetIn)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please slow down"})
+			return
+		}
+
+		c.Next()
+	}
+}
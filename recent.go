@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits for the recently completed list
+const (
+	defaultRecentLimit = 10
+	maxRecentLimit     = 50
+)
+
+// getRecentlyCompleted returns the user's latest completed tasks, most
+// recently completed first
+func getRecentlyCompleted(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	limit := defaultRecentLimit
+	if limitStr, ok := c.GetQuery("limit"); ok {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxRecentLimit {
+			limit = maxRecentLimit
+		}
+	}
+
+	tasks := []Task{}
+	if err := db.WithContext(c.Request.Context()).
+		Where("user_id = ? AND completed = ? AND archived_at IS NULL", userID, true).
+		Order("completed_at DESC NULLS LAST, id DESC").
+		Limit(limit).
+		Find(&tasks).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch tasks")
+		return
+	}
+
+	c.JSON(http.StatusOK, tasks)
+}
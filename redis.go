@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces limiter keys in a shared Redis
+const redisKeyPrefix = "ratelimit:"
+
+// newRedisClient connects using REDIS_HOST, REDIS_PORT, REDIS_PASSWORD and REDIS_DB
+func newRedisClient() (*redis.Client, error) {
+	dbIndex, err := strconv.Atoi(getEnv("REDIS_DB", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_DB: %w", err)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     net.JoinHostPort(getEnv("REDIS_HOST", "localhost"), getEnv("REDIS_PORT", "6379")),
+		Password: getEnv("REDIS_PASSWORD", ""),
+		DB:       dbIndex,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return client, nil
+}
+
+// redisRateLimitStore keeps fixed windows as expiring counters so every
+// replica sees the same counts and they survive restarts
+type redisRateLimitStore struct {
+	client *redis.Client
+}
+
+func newRedisRateLimitStore() (*redisRateLimitStore, error) {
+	client, err := newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimitStore{client: client}, nil
+}
+
+func (s *redisRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	key = redisKeyPrefix + key
+
+	var incr *redis.IntCmd
+	var ttl *redis.DurationCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		ttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The first hit starts the window; a missing TTL also repairs a key
+	// whose expiry was never set
+	resetIn := ttl.Val()
+	if resetIn < 0 {
+		if err := s.client.PExpire(ctx, key, window).Err(); err != nil {
+			return 0, 0, err
+		}
+		resetIn = window
+	}
+
+	return int(incr.Val()), resetIn, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultReminderWindow is how long a task stays quiet after a reminder
+const defaultReminderWindow = 24 * time.Hour
+
+// maxReminderBatch caps how many reminders one worker run may claim
+const maxReminderBatch = 500
+
+type ReminderSentRequest struct {
+	TaskIDs []TaskRef `json:"task_ids" binding:"required,min=1,max=500"`
+}
+
+// getDueReminders lists open tasks that have not been reminded about within
+// the reminder window, for the notification worker. Users who turned
+// reminders off are skipped.
+func getDueReminders(c *gin.Context) {
+	cutoff := time.Now().Add(-currentFeatures().ReminderWindow)
+
+	tasks := []Task{}
+	if err := db.WithContext(c.Request.Context()).
+		Joins("JOIN users ON users.id = tasks.user_id AND users.active = ? AND users.notify_due_soon_reminders = ?", true, true).
+		Where("tasks.completed = ? AND tasks.archived_at IS NULL", false).
+		Where("tasks.reminder_sent_at IS NULL OR tasks.reminder_sent_at < ?", cutoff).
+		Order("tasks.id ASC").
+		Limit(maxReminderBatch).
+		Find(&tasks).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch reminders")
+		return
+	}
+
+	c.JSON(http.StatusOK, tasks)
+}
+
+// markRemindersSent records that reminders went out so later runs skip them
+func markRemindersSent(c *gin.Context) {
+	var req ReminderSentRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	taskIDs, ok := resolveTaskRefs(c, req.TaskIDs)
+	if !ok {
+		return
+	}
+
+	result := db.WithContext(c.Request.Context()).Model(&Task{}).
+		Where("id IN ?", taskIDs).
+		Update("reminder_sent_at", time.Now())
+	if result.Error != nil {
+		respondDBError(c, result.Error, "Failed to mark reminders sent")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": result.RowsAffected})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownRenderer turns descriptions into HTML. Goldmark leaves raw HTML
+// out of its output unless told otherwise, and the sanitizer below catches
+// anything else, such as javascript: links.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// descriptionPolicy allows the formatting markdown produces and strips
+// scripts, event handlers, styles and unsafe URLs
+var descriptionPolicy = bluemonday.UGCPolicy()
+
+// RenderedTask is a task with its description also rendered to HTML
+type RenderedTask struct {
+	Task
+	DescriptionHTML string `json:"description_html"`
+}
+
+// renderMarkdown renders markdown to sanitized HTML that is safe to embed
+// in emails and pages
+func renderMarkdown(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return descriptionPolicy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setRetryAfter sets Retry-After to the backoff in whole seconds, rounding up
+// and never below one so clients cannot read it as "retry immediately". Every
+// 429 and 503 the API sends should go through this.
+func setRetryAfter(header http.Header, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	header.Set("Retry-After", strconv.Itoa(seconds))
+}
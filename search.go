@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchGroupLimit caps the number of results returned per group
+const searchGroupLimit = 20
+
+// likePattern escapes LIKE wildcards in a user-supplied term and wraps it for substring matching
+func likePattern(term string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(term) + "%"
+}
+
+// search returns the user's matches grouped by resource type
+func search(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	pattern := likePattern(q)
+
+	tasks := []Task{}
+	if err := db.WithContext(c.Request.Context()).
+		Where("user_id = ? AND archived_at IS NULL", userID).
+		Where(`title ILIKE ? ESCAPE '\' OR description ILIKE ? ESCAPE '\'`, pattern, pattern).
+		Order("updated_at DESC").
+		Limit(searchGroupLimit).
+		Find(&tasks).Error; err != nil {
+		respondDBError(c, err, "Failed to search")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query": q,
+		"tasks": tasks,
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Demo account created by --seed
+const (
+	demoUsername = "demo"
+	demoEmail    = "demo@example.com"
+	demoPassword = "demo1234"
+)
+
+// seedDemoData creates a demo user with sample tasks, skipping if the user already exists
+func seedDemoData() error {
+	var existing User
+	err := db.Where("username = ?", demoUsername).First(&existing).Error
+	if err == nil {
+		log.Printf("Demo user %q already exists, skipping seed", demoUsername)
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to look up demo user: %w", err)
+	}
+
+	hashedPassword, err := hashPassword(demoPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash demo password: %w", err)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		user := User{
+			Username:  demoUsername,
+			Email:     demoEmail,
+			Password:  hashedPassword,
+			Active:    true,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create demo user: %w", err)
+		}
+
+		tasks := []Task{
+			{Title: "Welcome to CheckMate", Description: "Explore the app with this demo account", Priority: "high"},
+			{Title: "Plan the week", Description: "Pick the three most important goals", Priority: "high"},
+			{Title: "Reply to emails", Description: "Clear the inbox before lunch", Priority: "medium"},
+			{Title: "Book dentist appointment", Priority: "medium"},
+			{Title: "Water the plants", Priority: "low"},
+			{Title: "Set up the project", Description: "Clone the repo and run make dev", Priority: "medium", Completed: true},
+		}
+		for i := range tasks {
+			tasks[i].UserID = user.ID
+			tasks[i].CreatedAt = time.Now().Add(-time.Duration(len(tasks)-i) * time.Hour)
+			tasks[i].UpdatedAt = tasks[i].CreatedAt
+			if tasks[i].Completed {
+				tasks[i].CompletedAt = &tasks[i].UpdatedAt
+			}
+		}
+		if err := tx.Create(&tasks).Error; err != nil {
+			return fmt.Errorf("failed to create demo tasks: %w", err)
+		}
+
+		log.Printf("Seeded demo user %q (password %q) with %d tasks", demoUsername, demoPassword, len(tasks))
+		return nil
+	})
+}
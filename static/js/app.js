@@ -3,6 +3,8 @@
 class TaskManager {
     constructor() {
         this.token = localStorage.getItem('token');
+        // In cookie auth mode the token lives in an HttpOnly cookie instead
+        this.cookieAuth = localStorage.getItem('cookieAuth') === 'true';
         this.user = JSON.parse(localStorage.getItem('user') || '{}');
         this.tasks = [];
         this.init();
@@ -142,8 +144,17 @@ class TaskManager {
         });
     }
 
+    csrfToken() {
+        const match = document.cookie.match(/(?:^|; )csrf_token=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    isLoggedIn() {
+        return Boolean(this.token) || this.cookieAuth;
+    }
+
     checkAuthStatus() {
-        if (this.token) {
+        if (this.isLoggedIn()) {
             this.showMainApp();
         } else {
             this.showAuthForms();
@@ -185,6 +196,9 @@ class TaskManager {
 
         if (this.token) {
             defaultOptions.headers['Authorization'] = `Bearer ${this.token}`;
+        } else if (this.cookieAuth) {
+            // Double-submit the CSRF cookie for cookie-authenticated requests
+            defaultOptions.headers['X-CSRF-Token'] = this.csrfToken();
         }
 
         const finalOptions = { ...defaultOptions, ...options };
@@ -195,7 +209,11 @@ class TaskManager {
             const data = await response.json();
 
             if (!response.ok) {
-                throw new Error(data.error || 'Request failed');
+                // Field errors arrive as a field to message map
+                const message = data.fields
+                    ? Object.values(data.fields).join(', ')
+                    : data.error;
+                throw new Error(message || 'Request failed');
             }
 
             return data;
@@ -222,10 +240,14 @@ class TaskManager {
                 body: JSON.stringify({ username, password }),
             });
 
-            this.token = data.token;
+            this.token = data.token || null;
+            this.cookieAuth = !data.token;
             this.user = data.user;
             
-            localStorage.setItem('token', this.token);
+            if (this.token) {
+                localStorage.setItem('token', this.token);
+            }
+            localStorage.setItem('cookieAuth', String(this.cookieAuth));
             localStorage.setItem('user', JSON.stringify(this.user));
 
             this.showToast('Success', 'Login successful!', 'success');
@@ -265,11 +287,20 @@ class TaskManager {
     }
 
     logout() {
+        if (this.cookieAuth) {
+            fetch('/api/logout', {
+                method: 'POST',
+                headers: { 'X-CSRF-Token': this.csrfToken() },
+            }).catch(() => {});
+        }
+
         this.token = null;
+        this.cookieAuth = false;
         this.user = {};
         this.tasks = [];
         
         localStorage.removeItem('token');
+        localStorage.removeItem('cookieAuth');
         localStorage.removeItem('user');
         
         this.showAuthForms();
@@ -277,7 +308,7 @@ class TaskManager {
     }
 
     async loadTasks() {
-        if (!this.token) return;
+        if (!this.isLoggedIn()) return;
 
         try {
             const tasks = await this.makeRequest('/api/tasks');
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// uuidBackfillBatch is how many tasks each backfill pass assigns UUIDs to
+const uuidBackfillBatch = 500
+
+// BeforeCreate gives every new task its public UUID
+func (t *Task) BeforeCreate(tx *gorm.DB) error {
+	if t.UUID == "" {
+		t.UUID = uuid.NewString()
+	}
+	return nil
+}
+
+// backfillTaskUUIDs assigns UUIDs to tasks created before the column existed.
+// It runs at startup after migration and does nothing once every task has one.
+func backfillTaskUUIDs(ctx context.Context) error {
+	total := 0
+	for {
+		var ids []uint
+		if err := db.WithContext(ctx).Model(&Task{}).Where("uuid IS NULL").
+			Limit(uuidBackfillBatch).Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("failed to find tasks without a uuid: %w", err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, id := range ids {
+				if err := tx.Model(&Task{}).Where("id = ?", id).UpdateColumn("uuid", uuid.NewString()).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to backfill task uuids: %w", err)
+		}
+		total += len(ids)
+	}
+
+	if total > 0 {
+		slog.Info("Backfilled task UUIDs", "count", total)
+	}
+	return nil
+}
+
+// parseTaskID reads a task ID path parameter, responding with 400 when it is
+// malformed. With USE_UUID_IDS on, only UUIDs are accepted, so integer IDs
+// cannot be walked to probe for other users' tasks; an unknown UUID is 404.
+func parseTaskID(c *gin.Context, param string) (uint, bool) {
+	value := c.Param(param)
+
+	if !currentFeatures().UseUUIDIDs {
+		var taskID uint
+		if _, err := fmt.Sscanf(value, "%d", &taskID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+			return 0, false
+		}
+		return taskID, true
+	}
+
+	publicID, err := uuid.Parse(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return 0, false
+	}
+
+	var task Task
+	if err := db.WithContext(c.Request.Context()).Select("id").Where("uuid = ?", publicID.String()).First(&task).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return 0, false
+		}
+		respondDBError(c, err, "Failed to fetch task")
+		return 0, false
+	}
+	return task.ID, true
+}
+
+// plainTask has Task's fields without its JSON method, for re-encoding
+type plainTask Task
+
+// taskJSON is a task as sent with USE_UUID_IDS on: id carries the UUID and
+// dependencies are listed by UUID, so the integer key never leaves the server
+type taskJSON struct {
+	plainTask
+	ID        string   `json:"id"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// publicTask prepares a task for encoding in UUID mode
+func publicTask(t Task) taskJSON {
+	return taskJSON{plainTask: plainTask(t), ID: t.UUID, DependsOn: t.dependsOnUUIDs}
+}
+
+// MarshalJSON encodes the task with its UUID as id when USE_UUID_IDS is on
+func (t Task) MarshalJSON() ([]byte, error) {
+	if !currentFeatures().UseUUIDIDs {
+		return json.Marshal(plainTask(t))
+	}
+	return json.Marshal(publicTask(t))
+}
+
+// MarshalJSON keeps description_html, which Task's own method would drop
+func (t RenderedTask) MarshalJSON() ([]byte, error) {
+	if !currentFeatures().UseUUIDIDs {
+		return json.Marshal(struct {
+			plainTask
+			DescriptionHTML string `json:"description_html"`
+		}{plainTask(t.Task), t.DescriptionHTML})
+	}
+	return json.Marshal(struct {
+		taskJSON
+		DescriptionHTML string `json:"description_html"`
+	}{publicTask(t.Task), t.DescriptionHTML})
+}
+
+// MarshalJSON keeps the deleted flag, which Task's own method would drop
+func (t SyncTask) MarshalJSON() ([]byte, error) {
+	if !currentFeatures().UseUUIDIDs {
+		return json.Marshal(struct {
+			plainTask
+			Deleted bool `json:"deleted"`
+		}{plainTask(t.Task), t.Deleted})
+	}
+	return json.Marshal(struct {
+		taskJSON
+		Deleted bool `json:"deleted"`
+	}{publicTask(t.Task), t.Deleted})
+}
+
+// taskPublicID is how a task is identified to clients
+func taskPublicID(t Task) interface{} {
+	if currentFeatures().UseUUIDIDs {
+		return t.UUID
+	}
+	return t.ID
+}
+
+// taskPublicIDs identifies several tasks to clients, in order
+func taskPublicIDs(tasks []Task) []interface{} {
+	ids := make([]interface{}, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, taskPublicID(task))
+	}
+	return ids
+}
+
+// TaskRef is a task ID in a request body: a number, or a UUID string with
+// USE_UUID_IDS on. resolveTaskRefs turns it into the primary key.
+type TaskRef struct {
+	id   uint
+	uuid string
+}
+
+func (r *TaskRef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.id); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &r.uuid); err != nil {
+		return errors.New("task ID must be a number or a UUID string")
+	}
+	return nil
+}
+
+// resolveTaskRefs maps body task IDs to primary keys, responding with 400
+// when one is not in the form the current mode expects. UUIDs matching no
+// task resolve to 0, which no task has, so callers treat them as not found.
+func resolveTaskRefs(c *gin.Context, refs []TaskRef) ([]uint, bool) {
+	ids := make([]uint, len(refs))
+	if !currentFeatures().UseUUIDIDs {
+		for i, ref := range refs {
+			if ref.uuid != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+				return nil, false
+			}
+			ids[i] = ref.id
+		}
+		return ids, true
+	}
+
+	uuids := make([]string, len(refs))
+	for i, ref := range refs {
+		publicID, err := uuid.Parse(ref.uuid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+			return nil, false
+		}
+		uuids[i] = publicID.String()
+	}
+
+	var tasks []Task
+	if err := db.WithContext(c.Request.Context()).Select("id", "uuid").Where("uuid IN ?", uuids).Find(&tasks).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch tasks")
+		return nil, false
+	}
+	byUUID := make(map[string]uint, len(tasks))
+	for _, task := range tasks {
+		byUUID[task.UUID] = task.ID
+	}
+	for i, publicID := range uuids {
+		ids[i] = byUUID[publicID]
+	}
+	return ids, true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRequestTimeout applies when REQUEST_TIMEOUT is unset or invalid
+const defaultRequestTimeout = 30 * time.Second
+
+// timeoutRetryAfter is the backoff suggested after a request times out,
+// giving whatever was slow a moment to recover
+const timeoutRetryAfter = 5 * time.Second
+
+// Long-lived streaming routes that must not be cut off or buffered
+var streamingRoutes = map[string]bool{
+	"/api/tasks/stream":   true,
+	"/api/ws":             true,
+	"/api/profile/export": true,
+}
+
+// getRequestTimeout reads REQUEST_TIMEOUT as a duration such as "30s"
+func getRequestTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return defaultRequestTimeout
+	}
+	return timeout
+}
+
+// timeoutMiddleware gives each request a context deadline. Context-aware
+// database calls abort once it passes, and whatever the handler then tries
+// to write is replaced by a 503.
+func timeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if streamingRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		tw := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = tw
+
+		c.Next()
+
+		// Handler returned without writing after the deadline
+		tw.expired()
+	}
+}
+
+// timeoutWriter swaps the response for a 503 once the request deadline has passed
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+// expired writes the timeout response the first time it is called after the
+// deadline, provided the handler has not already started its response
+func (w *timeoutWriter) expired() bool {
+	if w.timedOut {
+		return true
+	}
+	if !errors.Is(w.ctx.Err(), context.DeadlineExceeded) || w.ResponseWriter.Written() {
+		return false
+	}
+
+	w.timedOut = true
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	setRetryAfter(w.ResponseWriter.Header(), timeoutRetryAfter)
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	w.ResponseWriter.WriteString(`{"error":"Request timed out"}`)
+	return true
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.expired() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.expired() {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.expired() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
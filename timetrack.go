@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TimeRequest adds effort to a task's actual time, at most a day per entry
+type TimeRequest struct {
+	Minutes int `json:"minutes" binding:"required,min=1,max=1440"`
+}
+
+// Periods the time stats can be grouped by, as date_trunc units
+var timeStatsPeriods = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// timeStatsRow is one period of summed effort
+type timeStatsRow struct {
+	Start           string `json:"start" gorm:"column:period_start"`
+	EstimateMinutes int    `json:"estimate_minutes"`
+	ActualMinutes   int    `json:"actual_minutes"`
+	Tasks           int    `json:"tasks"`
+}
+
+// logTaskTime adds minutes to a task's actual time. The increment happens in
+// SQL so concurrent entries for the same task are not lost.
+func logTaskTime(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	taskID, ok := parseTaskID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req TimeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	task, ok := findUserTask(c, taskID, userID)
+	if !ok {
+		return
+	}
+
+	if err := db.WithContext(c.Request.Context()).Model(&task).Updates(map[string]interface{}{
+		"actual_minutes": gorm.Expr("actual_minutes + ?", req.Minutes),
+		"updated_at":     time.Now(),
+	}).Error; err != nil {
+		respondDBError(c, err, "Failed to log time")
+		return
+	}
+	if err := db.WithContext(c.Request.Context()).First(&task, task.ID).Error; err != nil {
+		respondDBError(c, err, "Failed to log time")
+		return
+	}
+
+	events.publish(TaskUpdated, task)
+
+	c.JSON(http.StatusOK, task)
+}
+
+// getTimeStats sums estimated and actual minutes per day, week or month in
+// the user's timezone. Effort is not timestamped per entry, so each task's
+// totals count towards the period it was created in. Tasks with no time
+// recorded are left out.
+func getTimeStats(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	period := c.DefaultQuery("period", "week")
+	if !timeStatsPeriods[period] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period, must be day, week or month"})
+		return
+	}
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).Select("id", "timezone").First(&user, userID).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch time stats")
+		return
+	}
+	loc := userLocation(user)
+
+	rows := []timeStatsRow{}
+	if err := db.WithContext(c.Request.Context()).Model(&Task{}).
+		Select("TO_CHAR(DATE_TRUNC(?, created_at AT TIME ZONE ?), 'YYYY-MM-DD') AS period_start, "+
+			"SUM(estimate_minutes) AS estimate_minutes, SUM(actual_minutes) AS actual_minutes, COUNT(*) AS tasks", period, loc.String()).
+		Where("user_id = ? AND (estimate_minutes > 0 OR actual_minutes > 0)", userID).
+		Group("period_start").
+		Order("period_start ASC").
+		Scan(&rows).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch time stats")
+		return
+	}
+
+	var totals timeStatsRow
+	for _, row := range rows {
+		totals.EstimateMinutes += row.EstimateMinutes
+		totals.ActualMinutes += row.ActualMinutes
+		totals.Tasks += row.Tasks
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"period":   period,
+		"timezone": loc.String(),
+		"periods":  rows,
+		"totals": gin.H{
+			"estimate_minutes": totals.EstimateMinutes,
+			"actual_minutes":   totals.ActualMinutes,
+			"tasks":            totals.Tasks,
+		},
+	})
+}
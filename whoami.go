@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Token scopes reported by whoami
+const (
+	scopeUser          = "user"
+	scopeImpersonation = "impersonation"
+	scopeGateway       = "gateway"
+)
+
+// whoami describes the caller's session from the validated token claims so
+// clients can manage expiry without decoding the JWT. Neither the token nor
+// anything about how it was signed is echoed back.
+func whoami(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var user User
+	if err := db.WithContext(c.Request.Context()).Select("id", "username").First(&user, userID).Error; err != nil {
+		respondDBError(c, err, "Failed to fetch user")
+		return
+	}
+
+	response := gin.H{
+		"user_id":  user.ID,
+		"username": user.Username,
+	}
+
+	// Gateway requests carry no token, only the proxy's identity header
+	value, ok := c.Get("claims")
+	if !ok {
+		response["scope"] = scopeGateway
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	claims := value.(*Claims)
+
+	response["scope"] = scopeUser
+	if claims.ImpersonatedBy != 0 {
+		response["scope"] = scopeImpersonation
+		response["impersonated_by"] = claims.ImpersonatedBy
+	}
+	if claims.IssuedAt != nil {
+		response["issued_at"] = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		response["expires_at"] = claims.ExpiresAt.Time
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// TokenValidationRequest carries a token to check
+type TokenValidationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// validateToken lets an edge layer check a token before proxying. It runs
+// the same checks as authMiddleware, including deactivation and revocation,
+// but changes nothing; any failure is a 401.
+func validateToken(c *gin.Context) {
+	var req TokenValidationRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	claims, err := parseToken(req.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+	if _, err := authorizeClaims(c.Request.Context(), claims); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"valid":   true,
+		"user_id": claims.UserID,
+		"scope":   scopeUser,
+	}
+	if claims.ImpersonatedBy != 0 {
+		response["scope"] = scopeImpersonation
+		response["impersonated_by"] = claims.ImpersonatedBy
+	}
+	if claims.ExpiresAt != nil {
+		response["expires_at"] = claims.ExpiresAt.Time
+	}
+
+	c.JSON(http.StatusOK, response)
+}
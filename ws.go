@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket timing settings
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = (wsPongWait * 9) / 10
+	wsMaxMessage = 4096
+)
+
+// SocketMessage is the envelope for messages sent by WebSocket clients
+type SocketMessage struct {
+	Type   string   `json:"type"`
+	Events []string `json:"events,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Origins are already unrestricted by the CORS middleware
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// socketUser authenticates a WebSocket request. Browsers cannot set headers
+// on WebSocket requests, so the token is a query param, or the auth cookie in
+// cookie mode; in gateway mode the proxy adds the identity header itself.
+// It responds with the error and returns false on failure.
+func socketUser(c *gin.Context) (uint, bool) {
+	if gatewayAuth() {
+		return gatewayUser(c)
+	}
+
+	token := c.Query("token")
+	if token == "" && cookieAuth() {
+		token, _ = c.Cookie(authCookieName)
+	}
+	claims, err := parseToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if status, err := authorizeClaims(c.Request.Context(), claims); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
+// taskSocket upgrades to a WebSocket and streams the user's task events
+func taskSocket(c *gin.Context) {
+	userID, ok := socketUser(c)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ch := events.subscribe(userID)
+	defer events.unsubscribe(userID, ch)
+
+	// Reader goroutine; all writes stay on this goroutine
+	incoming := make(chan SocketMessage)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(done)
+
+		conn.SetReadLimit(wsMaxMessage)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		for {
+			var msg SocketMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+			select {
+			case incoming <- msg:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	// Event types the client wants; empty means all
+	filter := map[string]bool{}
+
+	write := func(v interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(v) == nil
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-incoming:
+			var ok bool
+			switch msg.Type {
+			case "ping":
+				ok = write(gin.H{"type": "pong"})
+			case "subscribe":
+				filter = map[string]bool{}
+				for _, e := range msg.Events {
+					filter[e] = true
+				}
+				ok = write(gin.H{"type": "subscribed", "events": msg.Events})
+			default:
+				ok = write(gin.H{"type": "error", "error": "Unknown message type"})
+			}
+			if !ok {
+				return
+			}
+		case event, open := <-ch:
+			if !open {
+				return
+			}
+			if len(filter) > 0 && !filter[event.Type] {
+				continue
+			}
+			if !write(event) {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}